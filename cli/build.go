@@ -0,0 +1,65 @@
+package cli
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji/runner"
+)
+
+// workspaceModule is the module path of the workspace go.mod created by `keiji init`
+const workspaceModule = "workspace"
+
+// runnerFile is added to every task built by keiji, it hands runs of the executable over to the runner
+const runnerFile = "keiji_run.go"
+
+// RUNNER_PATH is the workspace package task executables import the runner from
+var RUNNER_PATH = filepath.Join(paths.WORKSPACE, "runner")
+
+var runnerFileContent = fmt.Sprintf(`// Code generated by keiji task build. DO NOT EDIT.
+
+package main
+
+import "%s/runner"
+
+// init hands runs of the task executable over to the keiji runner,
+// which records every run in the task's history
+func init() {
+	runner.Intercept(Function)
+}
+`, workspaceModule)
+
+/*
+installRunner copies the runner package shipped with the cli into the workspace,
+replacing the copy installed by a previous version. The runner only imports
+modules keiji-core depends on, so installing it never fetches a module
+*/
+func installRunner() error {
+	if err := os.RemoveAll(RUNNER_PATH); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(RUNNER_PATH, 0755); err != nil {
+		return err
+	}
+	return fs.WalkDir(runner.Source, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, "_test.go") {
+			return err
+		}
+		content, err := runner.Source.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(filepath.Join(RUNNER_PATH, path), content, 0644)
+	})
+}
+
+/*
+writeRunnerFile adds the file calling the runner to the source of the task named name
+*/
+func writeRunnerFile(name string) error {
+	return os.WriteFile(filepath.Join(paths.TASKS_PATH, name, runnerFile), []byte(runnerFileContent), 0644)
+}
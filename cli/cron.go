@@ -0,0 +1,117 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji/runner"
+)
+
+// maxCronPoll is the longest HMS interval a cron task may be polled at
+const maxCronPoll = time.Minute
+
+// cronIntervalSamples is the number of fire times checked for the shortest interval of an expression
+const cronIntervalSamples = 1000
+
+// legacyCronEnvKey held the cron expression of a task in its .env file before the cron directive
+const legacyCronEnvKey = "TASK_CRON"
+
+/*
+cronSchedule fires at the times matched by a cron expression in loc
+*/
+type cronSchedule struct {
+	schedule *runner.CronSchedule
+	loc      *time.Location
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	return s.schedule.Next(t.In(s.loc))
+}
+
+// parseCronDirective parses the arguments of a cron directive, the expression may be quoted
+// e.g `0 */15 9-17 * * MON-FRI` or `"@hourly"`
+func parseCronDirective(args string) (string, error) {
+	expr := strings.TrimSpace(strings.Trim(strings.TrimSpace(args), `"`))
+	if _, err := runner.ParseCron(expr); err != nil {
+		return "", err
+	}
+	return expr, nil
+}
+
+/*
+addCronDirective declares expr as the cron expression of the task named name,
+above the Schedule function of its schedule.go
+*/
+func addCronDirective(name string, expr string) error {
+	path := filepath.Join(paths.TASKS_PATH, name, "schedule.go")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	for i, line := range lines {
+		if strings.HasPrefix(line, "func Schedule(") {
+			directive := fmt.Sprintf("%scron %s", directivePrefix, expr)
+			lines = append(lines[:i], append([]string{directive}, lines[i:]...)...)
+			return os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644)
+		}
+	}
+	return fmt.Errorf("func Schedule not found in %v, declare `%scron %s` above it", path, directivePrefix, expr)
+}
+
+/*
+minFireInterval returns the shortest time between consecutive fire times of
+schedule among the next samples fire times after from
+*/
+func minFireInterval(schedule *runner.CronSchedule, from time.Time, samples int) time.Duration {
+	shortest := time.Duration(0)
+	previous := schedule.Next(from)
+	for i := 0; i < samples && !previous.IsZero(); i++ {
+		next := schedule.Next(previous)
+		if next.IsZero() {
+			break
+		}
+		if gap := next.Sub(previous); shortest == 0 || gap < shortest {
+			shortest = gap
+		}
+		previous = next
+	}
+	return shortest
+}
+
+/*
+checkCronPoll confirms that the HMS schedule the scheduler starts the cron task
+on polls the expression often enough. A run executes the latest fire time since
+the previous one, so the expression may not fire more often than it is polled
+and a fire time runs up to one poll interval late
+*/
+func checkCronPoll(task *db.TaskModel, expr string) error {
+	if task.Type != db.HMSTask {
+		return fmt.Errorf("cron tasks must be scheduled with an HMS schedule polling the expression e.g tasks.NewSchedule().Run().Every(1).Minutes().Build(), got %v", task.Type)
+	}
+	poll, err := hmsInterval(task)
+	if err != nil {
+		return err
+	}
+	if poll > maxCronPoll {
+		return fmt.Errorf("cron tasks must be polled at least every %v, schedule.go runs the task every %v", maxCronPoll, poll)
+	}
+	schedule, err := runner.ParseCron(expr)
+	if err != nil {
+		return err
+	}
+	loc, err := runner.Location()
+	if err != nil {
+		return err
+	}
+	interval := minFireInterval(schedule, time.Now().In(loc), cronIntervalSamples)
+	if interval > 0 && interval < poll {
+		return fmt.Errorf("cron expression %q fires every %v, more often than schedule.go polls it every %v", expr, interval, poll)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji/runner"
+)
+
+func TestMinFireInterval(t *testing.T) {
+	from := time.Date(2026, time.March, 6, 10, 7, 30, 0, time.UTC)
+	tests := []struct {
+		name string
+		expr string
+		want time.Duration
+	}{
+		{name: "every second", expr: "* * * * * *", want: time.Second},
+		{name: "every 15 minutes in office hours", expr: "0 */15 9-17 * * MON-FRI", want: 15 * time.Minute},
+		{name: "irregular list", expr: "0 0,10,50 * * * *", want: 10 * time.Minute},
+		{name: "daily", expr: "@daily", want: 24 * time.Hour},
+		{name: "never fires", expr: "0 0 30 2 *", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := runner.ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := minFireInterval(schedule, from, cronIntervalSamples); got != tt.want {
+				t.Errorf("minFireInterval(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckCronPoll(t *testing.T) {
+	t.Setenv("TIME_ZONE", "UTC")
+	tests := []struct {
+		name    string
+		task    db.TaskModel
+		expr    string
+		wantErr bool
+	}{
+		{
+			name: "polled every 10 seconds",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:10"},
+			expr: "0 */15 9-17 * * MON-FRI",
+		},
+		{
+			name: "polled every minute",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:minutes,interval:1"},
+			expr: "*/5 * * * *",
+		},
+		{
+			name: "fires as often as it is polled",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:1"},
+			expr: "* * * * * *",
+		},
+		{
+			name:    "fires more often than it is polled",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:30"},
+			expr:    "*/20 * * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "seconds finer than a one minute poll",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:minutes,interval:1"},
+			expr:    "0,30 * * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "polled less than every minute",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:minutes,interval:5"},
+			expr:    "@hourly",
+			wantErr: true,
+		},
+		{
+			name:    "daytime schedule",
+			task:    db.TaskModel{Type: db.DayTimeTask, Schedule: "day:friday,time:10:00PM"},
+			expr:    "@hourly",
+			wantErr: true,
+		},
+		{
+			name:    "invalid expression",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:10"},
+			expr:    "0 9 * *",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.Name = "report"
+			if err := checkCronPoll(&tt.task, tt.expr); (err != nil) != tt.wantErr {
+				t.Errorf("checkCronPoll(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,348 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/aodr3w/keiji/runner"
+	"github.com/joho/godotenv"
+	"github.com/spf13/cobra"
+)
+
+// envEscaper escapes the characters that godotenv interprets inside double quotes
+var envEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	"\n", `\n`,
+	"\r", `\r`,
+	`"`, `\"`,
+	`!`, `\!`,
+	`$`, `\$`,
+	"`", "\\`",
+)
+
+func taskEnvPath(name string) string {
+	return filepath.Join(paths.TASKS_PATH, name, ".env")
+}
+
+/*
+readTaskEnv returns the variables of the task's .env file, empty if the file does not exist
+*/
+func readTaskEnv(name string) (map[string]string, error) {
+	env, err := godotenv.Read(taskEnvPath(name))
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	return env, err
+}
+
+/*
+formatEnvValue returns a representation of value that godotenv reads back unchanged.
+Values are double quoted when possible, godotenv cannot read double quoted values
+ending with " or \ so single quotes then no quotes are tried next
+*/
+func formatEnvValue(value string) (string, error) {
+	candidates := []string{`"` + envEscaper.Replace(value) + `"`, "'" + value + "'", value}
+	for _, candidate := range candidates {
+		env, err := godotenv.Unmarshal("KEY=" + candidate)
+		if err == nil && len(env) == 1 && env["KEY"] == value {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("the value cannot be stored in a .env file, set it with --secret instead")
+}
+
+/*
+writeTaskEnv replaces the task's .env file with env. godotenv.Write is not used
+because it strips leading zeros from numeric values and mangles some quoted values
+*/
+func writeTaskEnv(name string, env map[string]string) error {
+	keys := make([]string, 0, len(env))
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var content strings.Builder
+	for _, key := range keys {
+		value, err := formatEnvValue(env[key])
+		if err != nil {
+			return fmt.Errorf("invalid value of %v: %v", key, err)
+		}
+		fmt.Fprintf(&content, "%s=%s\n", key, value)
+	}
+	return os.WriteFile(taskEnvPath(name), []byte(content.String()), 0644)
+}
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// managedEnvKeys are edited through their own commands, `keiji task rename` and `keiji task tag`
+var managedEnvKeys = []string{"TASK_NAME", tagsEnvKey}
+
+func checkEnvKey(key string) error {
+	if !envKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid variable name %q, names may only contain letters, digits and _ and cannot start with a digit", key)
+	}
+	if containsFold(managedEnvKeys, key) {
+		return fmt.Errorf("%v is managed by keiji and cannot be edited with `keiji task env`", key)
+	}
+	return nil
+}
+
+/*
+EnvVarView is the serialized representation of a task's environment variable,
+the values of secrets are masked
+*/
+type EnvVarView struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Secret bool   `json:"secret" yaml:"secret"`
+}
+
+/*
+checkTaskSource returns cmdErrors.TaskNotFound if the task has no source folder
+*/
+func checkTaskSource(name string) error {
+	exists, err := utils.PathExists(filepath.Join(paths.TASKS_PATH, name))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return cmdErrors.ErrTaskNotFound(name)
+	}
+	return nil
+}
+
+/*
+setTaskEnv sets the variables of pairs, formatted KEY=VALUE, in the task's .env file or
+in the secrets store when secret is true. A secret provided without a value is read from stdin
+*/
+func setTaskEnv(name string, pairs []string, secret bool) error {
+	if err := checkTaskSource(name); err != nil {
+		return err
+	}
+	values := make(map[string]string, len(pairs))
+	keys := make([]string, 0, len(pairs))
+	for _, pair := range pairs {
+		key, value, ok := strings.Cut(pair, "=")
+		if err := checkEnvKey(key); err != nil {
+			return err
+		}
+		if !ok {
+			if !secret {
+				return fmt.Errorf("expected KEY=VALUE, got %q", pair)
+			}
+			if info, err := os.Stdin.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+				fmt.Fprintf(os.Stderr, "value of %v: ", key)
+			}
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && err != io.EOF {
+				return err
+			}
+			value = strings.TrimRight(line, "\r\n")
+		}
+		values[key] = value
+		keys = append(keys, key)
+	}
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if !secret {
+			env[key] = values[key]
+			if _, err := unsetSecret(name, key); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := setSecret(name, key, values[key]); err != nil {
+			return err
+		}
+		//a secret replaces a plain variable of the same name
+		delete(env, key)
+	}
+	if err := writeTaskEnv(name, env); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("set %v on task %v", strings.Join(keys, ", "), name))
+	return nil
+}
+
+/*
+unsetTaskEnv removes variables and secrets from the task
+*/
+func unsetTaskEnv(name string, keys []string) error {
+	if err := checkTaskSource(name); err != nil {
+		return err
+	}
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		if err := checkEnvKey(key); err != nil {
+			return err
+		}
+		removed, err := unsetSecret(name, key)
+		if err != nil {
+			return err
+		}
+		if _, ok := env[key]; !ok && !removed {
+			return fmt.Errorf("variable %v is not set on task %v", key, name)
+		}
+		delete(env, key)
+	}
+	if err := writeTaskEnv(name, env); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("unset %v on task %v", strings.Join(keys, ", "), name))
+	return nil
+}
+
+func listTaskEnv(name string) error {
+	if err := checkTaskSource(name); err != nil {
+		return err
+	}
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return err
+	}
+	store, err := runner.ReadSecretStore()
+	if err != nil {
+		return err
+	}
+	views := make([]EnvVarView, 0, len(env)+len(store[name]))
+	for key, value := range env {
+		views = append(views, EnvVarView{Key: key, Value: value})
+	}
+	for key := range store[name] {
+		views = append(views, EnvVarView{Key: key, Value: secretMask, Secret: true})
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].Key < views[j].Key })
+	return printOutput("EnvVarList", views, func(w io.Writer, wide bool) {
+		fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+		for _, v := range views {
+			source := ".env"
+			if v.Secret {
+				source = "secret"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Key, strconv.Quote(v.Value), source)
+		}
+	})
+}
+
+/*
+runEnv returns the environment of a task process and the decrypted secrets of the task.
+The environment of keiji is passed on without its internal variables e.g KEIJI_SECRETS_KEY,
+the runner inside the executable sets the task's .env variables and secrets
+*/
+func runEnv(name string) ([]string, map[string]string, error) {
+	secrets, err := runner.TaskSecrets(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	return runner.CleanEnv(os.Environ()), secrets, nil
+}
+
+/*
+envArgs splits the arguments of the env subcommands into the task name and the
+remaining arguments, the name is the first argument unless --name is provided
+*/
+func envArgs(name *string, rest *[]string, min int) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if !valid(*name) {
+			if len(args) == 0 {
+				return fmt.Errorf("please provide name for your task")
+			}
+			*name, args = args[0], args[1:]
+		}
+		if len(args) < min {
+			return fmt.Errorf("expected at least %d variables after the task name", min)
+		}
+		*rest = args
+		return nil
+	}
+}
+
+func newTaskEnvCMD() *cobra.Command {
+	envCMD := &cobra.Command{
+		Use:   "env",
+		Short: "manage task environment variables and secrets",
+		Long: "commands to edit the variables of a task's .env file and its secrets.\n" +
+			"Secrets are encrypted in " + runner.SECRETS_PATH + ", the task executable sets them in its environment on every run and they are never printed",
+	}
+	envCMD.AddCommand(newTaskEnvSetCMD(), newTaskEnvUnsetCMD(), newTaskEnvListCMD())
+	return envCMD
+}
+
+func newTaskEnvSetCMD() *cobra.Command {
+	var name string
+	var pairs []string
+	var secret bool
+	cmd := &cobra.Command{
+		Use:   "set NAME KEY=VALUE...",
+		Short: "set task environment variables",
+		Long: "sets variables in the task's .env file, or in the secrets store with --secret.\n" +
+			"The value of a secret provided as KEY only is read from stdin, keeping it out of the shell history",
+		Example: "keiji task env set --name=report SMTP_HOST=mail.example.com SMTP_PORT=587\n" +
+			"keiji task env set report SMTP_PASSWORD --secret",
+		Args: envArgs(&name, &pairs, 1),
+		RunE: taskAction(func() error {
+			return setTaskEnv(name, pairs, secret)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().BoolVar(&secret, "secret", false, "encrypt the values in the secrets store")
+	return cmd
+}
+
+func newTaskEnvUnsetCMD() *cobra.Command {
+	var name string
+	var keys []string
+	cmd := &cobra.Command{
+		Use:     "unset NAME KEY...",
+		Short:   "remove task environment variables",
+		Long:    "removes variables from the task's .env file and the secrets store",
+		Example: "keiji task env unset --name=report SMTP_HOST SMTP_PASSWORD",
+		Args:    envArgs(&name, &keys, 1),
+		RunE: taskAction(func() error {
+			return unsetTaskEnv(name, keys)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	return cmd
+}
+
+func newTaskEnvListCMD() *cobra.Command {
+	var name string
+	var rest []string
+	cmd := &cobra.Command{
+		Use:     "list NAME",
+		Short:   "list task environment variables",
+		Long:    "prints the variables of the task's .env file and the names of its secrets, secret values are masked",
+		Example: "keiji task env list report -o json",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if err := envArgs(&name, &rest, 0)(cmd, args); err != nil {
+				return err
+			}
+			if len(rest) > 0 {
+				return fmt.Errorf("unexpected arguments %v", rest)
+			}
+			return nil
+		},
+		RunE: taskAction(func() error {
+			return listTaskEnv(name)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	return cmd
+}
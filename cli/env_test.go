@@ -0,0 +1,79 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aodr3w/keiji-core/paths"
+)
+
+func TestWriteTaskEnvRoundTrip(t *testing.T) {
+	tasksPath := paths.TASKS_PATH
+	t.Cleanup(func() { paths.TASKS_PATH = tasksPath })
+	paths.TASKS_PATH = t.TempDir()
+	if err := os.Mkdir(filepath.Join(paths.TASKS_PATH, "report"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		value   string
+		wantErr bool
+	}{
+		{name: "plain", value: "mail.example.com"},
+		{name: "empty", value: ""},
+		{name: "leading zeros", value: "00587"},
+		{name: "spaces", value: "  padded value  "},
+		{name: "double quotes", value: `say "hi"`},
+		{name: "single quotes", value: `it's`},
+		{name: "backslashes", value: `C:\temp\new`},
+		{name: "trailing backslash", value: `ends with \`},
+		{name: "new lines", value: "line 1\nline 2\r\n"},
+		{name: "dollar", value: "$HOME and ${USER}"},
+		{name: "backticks", value: "`whoami`"},
+		{name: "exclamation", value: "hello!"},
+		{name: "hash", value: "a # not a comment"},
+		{name: "equals", value: "a=b=c"},
+		{name: "unicode", value: "héllo 世界"},
+		{name: "trailing double quote", value: `"quoted"`},
+		{name: "quotes and trailing double quote", value: `it's "quoted"`},
+		{name: "trailing backslash and variable", value: `$HOME\`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := writeTaskEnv("report", map[string]string{"VALUE": tt.value, "OTHER": "x"})
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error writing %q", tt.value)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			env, err := readTaskEnv("report")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := env["VALUE"]; got != tt.value {
+				t.Errorf("round trip of %q returned %q", tt.value, got)
+			}
+			if got := env["OTHER"]; got != "x" {
+				t.Errorf("value of OTHER changed to %q", got)
+			}
+		})
+	}
+}
+
+func TestReadTaskEnvMissingFile(t *testing.T) {
+	tasksPath := paths.TASKS_PATH
+	t.Cleanup(func() { paths.TASKS_PATH = tasksPath })
+	paths.TASKS_PATH = t.TempDir()
+	env, err := readTaskEnv("missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(env) != 0 {
+		t.Errorf("expected no variables, got %v", env)
+	}
+}
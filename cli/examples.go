@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+)
+
+// examplesFS holds the source of the example tasks, they are compiled with the cli so that they always build
+//
+//go:embed examples
+var examplesFS embed.FS
+
+/*
+ExampleTask is a working task shipped with the cli
+*/
+type ExampleTask struct {
+	Name        string
+	Description string
+}
+
+var exampleTasks = []ExampleTask{
+	{Name: "ping_google", Description: "checks that google.com responds every 30 seconds"},
+	{Name: "calculator", Description: "evaluates CALCULATOR_EXPRESSION every minute"},
+	{Name: "crypto_scraper", Description: "logs bitcoin and ethereum prices every monday"},
+}
+
+func exampleNames() []string {
+	names := make([]string, 0, len(exampleTasks))
+	for _, example := range exampleTasks {
+		names = append(names, example.Name)
+	}
+	return names
+}
+
+func getExample(name string) (*ExampleTask, error) {
+	for _, example := range exampleTasks {
+		if example.Name == name {
+			return &example, nil
+		}
+	}
+	return nil, fmt.Errorf("example %v not found, available examples: %v", name, strings.Join(exampleNames(), ", "))
+}
+
+/*
+copyExample writes the source of the example named name into taskPath
+*/
+func copyExample(name string, taskPath string) error {
+	root := "examples/" + name
+	return fs.WalkDir(examplesFS, root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(taskPath, strings.TrimPrefix(path, root))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		content, err := examplesFS.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, content, 0644)
+	})
+}
+
+/*
+createExamples scaffolds every example task that does not exist in the workspace yet
+*/
+func createExamples() error {
+	created := 0
+	for _, example := range exampleTasks {
+		exists, err := utils.PathExists(filepath.Join(paths.TASKS_PATH, example.Name))
+		if err != nil {
+			return err
+		}
+		if exists {
+			logWarn(fmt.Sprintf("example %v already exists in %v, skipping", example.Name, paths.TASKS_PATH))
+			continue
+		}
+		if err := createTask(example.Name, example.Description, CreateOptions{Example: example.Name}); err != nil {
+			return err
+		}
+		created++
+	}
+	if created == 0 {
+		return nil
+	}
+	logInfo("examples created, build them with `keiji task build --all`")
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultExpression is evaluated when CALCULATOR_EXPRESSION is not set
+const defaultExpression = "3 4 + 2 *"
+
+var errDivisionByZero = errors.New("division by zero")
+
+/*
+Function evaluates the reverse polish notation expression in CALCULATOR_EXPRESSION
+e.g `3 4 + 2 *` and logs the result. Invalid expressions fail the run
+*/
+func Function() error {
+	expression := os.Getenv("CALCULATOR_EXPRESSION")
+	if len(strings.TrimSpace(expression)) == 0 {
+		expression = defaultExpression
+	}
+	result, err := evaluate(expression)
+	if errors.Is(err, errDivisionByZero) {
+		return fmt.Errorf("cannot evaluate %q: %w, check CALCULATOR_EXPRESSION", expression, err)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot evaluate %q: %w", expression, err)
+	}
+	log.Printf("%v = %v", expression, result)
+	return nil
+}
+
+func evaluate(expression string) (float64, error) {
+	stack := make([]float64, 0)
+	for _, token := range strings.Fields(expression) {
+		if value, err := strconv.ParseFloat(token, 64); err == nil {
+			stack = append(stack, value)
+			continue
+		}
+		if len(stack) < 2 {
+			return 0, fmt.Errorf("operator %v needs two operands", token)
+		}
+		a, b := stack[len(stack)-2], stack[len(stack)-1]
+		stack = stack[:len(stack)-2]
+		switch token {
+		case "+":
+			stack = append(stack, a+b)
+		case "-":
+			stack = append(stack, a-b)
+		case "*":
+			stack = append(stack, a*b)
+		case "/":
+			if b == 0 {
+				return 0, errDivisionByZero
+			}
+			stack = append(stack, a/b)
+		default:
+			return 0, fmt.Errorf("unknown operator %v", token)
+		}
+	}
+	if len(stack) != 1 {
+		return 0, fmt.Errorf("expected a single result, got %v", stack)
+	}
+	return stack[0], nil
+}
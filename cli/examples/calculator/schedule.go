@@ -0,0 +1,12 @@
+package main
+
+import (
+	"github.com/aodr3w/keiji-core/tasks"
+)
+
+/*
+Schedule runs the task every minute, an HMS schedule
+*/
+func Schedule() error {
+	return tasks.NewSchedule().Run().Every(1).Minutes().Build()
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// coins are the coingecko ids of the scraped coins
+var coins = []string{"bitcoin", "ethereum"}
+
+const pricesURL = "https://api.coingecko.com/api/v3/simple/price?vs_currencies=usd&ids="
+
+/*
+Function scrapes the USD price of coins from the coingecko API and logs them
+*/
+func Function() error {
+	client := http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Get(pricesURL + strings.Join(coins, ","))
+	if err != nil {
+		return fmt.Errorf("coingecko is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		//the status code is part of the error so that the retry directive can match 429
+		return fmt.Errorf("coingecko responded with %v", resp.Status)
+	}
+	prices := make(map[string]map[string]float64)
+	if err := json.NewDecoder(resp.Body).Decode(&prices); err != nil {
+		return fmt.Errorf("invalid coingecko response: %w", err)
+	}
+	missing := make([]string, 0)
+	for _, coin := range coins {
+		if _, ok := prices[coin]["usd"]; !ok {
+			missing = append(missing, coin)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no price for %v", strings.Join(missing, ", "))
+	}
+	for _, coin := range coins {
+		log.Printf("%v: %.2f USD", coin, prices[coin]["usd"])
+	}
+	return nil
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aodr3w/keiji-core/tasks"
+)
+
+/*
+Schedule runs the task every Monday at 09:00 in the workspace TIME_ZONE, a DayTime schedule.
+Rate limited requests are retried with exponential backoff, other errors are not
+*/
+//keiji:retry attempts=4 backoff=exponential delay=30s retry-on="429"
+//keiji:timeout 1m
+func Schedule() error {
+	return tasks.NewSchedule().On().Monday().At("09:00").Build()
+}
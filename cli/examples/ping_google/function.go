@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/*
+Function checks that google.com responds. Returning an error marks the run as
+failed, the error is saved in the run history and in the task's ErrorTxt
+*/
+func Function() error {
+	client := http.Client{Timeout: 10 * time.Second}
+	start := time.Now()
+	resp, err := client.Get("https://www.google.com")
+	if err != nil {
+		return fmt.Errorf("google.com is unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("google.com responded with %v", resp.Status)
+	}
+	log.Printf("google.com responded with %v in %v", resp.Status, time.Since(start).Round(time.Millisecond))
+	return nil
+}
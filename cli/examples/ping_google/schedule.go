@@ -0,0 +1,15 @@
+package main
+
+import (
+	"github.com/aodr3w/keiji-core/tasks"
+)
+
+/*
+Schedule runs the task every 30 seconds, an HMS schedule.
+A run that fails is retried twice, 5 seconds apart
+*/
+//keiji:retry attempts=3 delay=5s
+//keiji:timeout 20s
+func Schedule() error {
+	return tasks.NewSchedule().Run().Every(30).Seconds().Build()
+}
@@ -10,9 +10,9 @@ import (
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/aodr3w/keiji-core/bus"
@@ -23,8 +23,10 @@ import (
 	"github.com/aodr3w/keiji-core/paths"
 	"github.com/aodr3w/keiji-core/utils"
 	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/aodr3w/keiji/runner"
 	"github.com/logrusorgru/aurora"
 	"github.com/spf13/cobra"
+	"gorm.io/gorm"
 )
 
 var bc = bus.NewBusClient()
@@ -64,7 +66,11 @@ The caller of this function must remember to call instance.close()
 when done with the instance to prevent memory leaks
 */
 func newRepo() (*db.Repo, error) {
-	return db.NewRepo()
+	repo, err := db.NewRepo()
+	if err != nil {
+		return nil, err
+	}
+	return repo, repo.DB.AutoMigrate(cliModels...)
 }
 
 /*
@@ -74,7 +80,7 @@ has been successfully initialized by the user
 func checkWorkSpace() error {
 	var err error
 	if !utils.IsInit() {
-		return fmt.Errorf("please initialize your workspace to continue")
+		return cmdErrors.ErrWorkSpaceNotInitialized
 	}
 	if cmdRepo == nil {
 		cmdRepo, err = newRepo()
@@ -83,12 +89,21 @@ func checkWorkSpace() error {
 }
 
 func init() {
+	rootCmd.SilenceErrors = true
 	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if err := checkOutputFormat(); err != nil {
+			return err
+		}
+		//arguments are valid at this point, failures are no longer usage errors
+		cmd.SilenceUsage = true
 		return nil
 	}
+	rootCmd.PersistentFlags().StringVarP(&output, "output", "o", string(TABLE), "output format: table, wide, json or yaml")
 	rootCmd.AddCommand(NewInitCMD())
 	rootCmd.AddCommand(NewTaskCMD())
 	rootCmd.AddCommand(NewSystemCMD())
+	rootCmd.AddCommand(NewLogsCMD())
+	rootCmd.AddCommand(NewTemplateCMD())
 }
 
 /*
@@ -134,7 +149,7 @@ func createWorkSpace() error {
 		return err
 	}
 	//do a go mod init workspace
-	err = runCMD(paths.WORKSPACE, true, "go", "mod", "init", "workspace")
+	err = runCMD(paths.WORKSPACE, true, "go", "mod", "init", workspaceModule)
 	if err != nil && !strings.Contains(err.Error(), "already exists") {
 		return err
 	}
@@ -242,35 +257,43 @@ func InstallService(service c.Service, update bool, cc bool) error {
 
 }
 func NewInitCMD() *cobra.Command {
-	return &cobra.Command{
+	var examples bool
+	initCMD := &cobra.Command{
 		Use:   "init",
 		Short: "initialize workspace",
-		Long:  "initializes workspace by creating required directories and installing services",
+		Long: "initializes workspace by creating required directories and installing services.\n" +
+			"With --examples, example tasks demonstrating schedules, error handling and logging are added to the workspace",
+		Example: "keiji init --examples",
 		RunE: func(cmd *cobra.Command, args []string) error {
 			//initialize work space folder
 			if !utils.IsInit() {
 				logWarn("Initializing work space...")
 				err := createWorkSpace()
 				if err != nil {
-					logError(err)
-					return nil
+					return err
 				}
 				cmdRepo, err = newRepo()
 				if err != nil {
-					logError(err)
-					return nil
+					return err
 				}
 			} else {
 				logInfo("workspace already initialized.")
 			}
+			if examples {
+				if err := checkWorkSpace(); err != nil {
+					return err
+				}
+				if err := createExamples(); err != nil {
+					return err
+				}
+			}
 			//install services after initializing work space
 			missingServices := make([]c.Service, 0)
 			allInstalled := true
 			for service := range serviceRepos {
 				installed, err := isServiceInstalled(service)
 				if err != nil {
-					logError(err)
-					return nil
+					return err
 				}
 				if !installed {
 					logError(fmt.Sprintf("service %s not found", service))
@@ -284,8 +307,7 @@ func NewInitCMD() *cobra.Command {
 				for _, s := range missingServices {
 					err := InstallService(s, false, false)
 					if err != nil {
-						logError(err)
-						return nil
+						return err
 					}
 				}
 			} else {
@@ -309,24 +331,10 @@ func NewInitCMD() *cobra.Command {
 			return nil
 		},
 	}
+	initCMD.Flags().BoolVar(&examples, "examples", false, fmt.Sprintf("add the example tasks %v to the workspace", strings.Join(exampleNames(), ", ")))
+	return initCMD
 }
 
-func installAllServices(update bool, cc bool) error {
-	if cc {
-		err := clearCache()
-		if err != nil {
-			return err
-		}
-	}
-	logWarn("installing all services...")
-	for _, s := range c.SERVICES {
-		err := InstallService(s, update, false)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
 func clearCache() error {
 	logWarn("cleaning modcache...")
 	return runCMD(paths.WORKSPACE, true, "go", "clean", "-modcache")
@@ -387,7 +395,7 @@ func getServicePath(service c.Service) (string, error) {
 func uninstallSystem() error {
 	//confirm use of sudo priviledges
 	if os.Geteuid() != 0 {
-		return fmt.Errorf("this command must be run as root. Please use sudo")
+		return cmdErrors.ErrPermissionDenied("this command must be run as root. Please use sudo")
 	}
 	//remove all services
 	for _, service := range c.SERVICES {
@@ -449,63 +457,35 @@ func uninstallSystem() error {
 }
 
 /*
-returns a boolean denoting wether a service is running or not
+returns a boolean denoting wether a service is running or not,
+a pid file pointing at a process that is not the service is stale
 */
 func isServiceRunning(service c.Service) bool {
-	pidPath := paths.PID_PATH(service)
-	pid, err := readPID(pidPath)
-	if err != nil {
-		logError(fmt.Sprintf("%s: %v", pidPath, err))
-		return false
-	}
-	err = syscall.Kill(pid, 0)
+	process, err := readServiceProcess(service)
 	if err != nil {
-		if err == syscall.ESRCH {
-			return false
-		} else if err == syscall.EPERM {
-			logError("permission denied")
-			return false
-		}
-		logError(fmt.Sprintf("%d: %v", pid, err))
+		logError(fmt.Sprintf("%s: %v", paths.PID_PATH(service), err))
 		return false
 	}
-	return true
+	return process != nil && !valid(process.Stale)
 }
 
 /*
-get status of all services installed or not, running or not
+get status of all services installed or not, running or not, followed by a summary of the tasks
 */
-func getServiceInfo() {
-	report := make(map[c.Service]c.ServiceStatus)
+func getServiceInfo() error {
+	report := make([]ServiceView, 0, len(c.SERVICES))
 	for _, service := range c.SERVICES {
-		ok, err := isServiceInstalled(service)
+		view, err := newServiceView(service)
 		if err != nil {
 			logError(err)
-			continue
-		}
-		if !ok {
-			logError(fmt.Sprintf("service %s not found", service))
-			continue
-		}
-		ok = isServiceRunning(service)
-		if ok {
-			report[service] = c.ONLINE
-		} else {
-			report[service] = c.OFFLINE
 		}
+		report = append(report, view)
 	}
-
-	// Print header
-	fmt.Println(strings.Repeat("=", 8), "SERVICES", strings.Repeat("=", 8))
-	fmt.Printf("%-18s %-18s\n", "NAME", "STATUS")
-
-	// Print each service status
-	for k, v := range report {
-		fmt.Printf("%-18s %-18s\n", k, v)
+	summary, err := newTaskSummary()
+	if err != nil {
+		logError(err)
 	}
-
-	// Print footer
-	fmt.Println(strings.Repeat("=", 8), "SERVICES", strings.Repeat("=", 8))
+	return printServices(report, summary)
 }
 func logInfo(msg interface{}) {
 	log.Println(aurora.Green(msg))
@@ -518,51 +498,106 @@ func logError(msg interface{}) {
 	log.Println(aurora.Red(msg))
 }
 
+/*
+taskNameArgs validates that a task name has been supplied exactly once,
+either as a positional argument or through the --name flag. The resolved
+name is written back into name for use by the command's RunE
+*/
+func taskNameArgs(name *string) cobra.PositionalArgs {
+	return func(cmd *cobra.Command, args []string) error {
+		if len(args) > 1 {
+			return fmt.Errorf("expected a single task name, got %d: %v", len(args), args)
+		}
+		if len(args) == 1 {
+			if valid(*name) && *name != args[0] {
+				return fmt.Errorf("task name provided twice: %q and --name=%q", args[0], *name)
+			}
+			*name = args[0]
+		}
+		if !valid(*name) {
+			return fmt.Errorf("please provide name for your task")
+		}
+		return nil
+	}
+}
+
+/*
+taskAction wraps a task operation so that workspace validation
+behaves the same across all task subcommands
+*/
+func taskAction(fn func() error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := checkWorkSpace(); err != nil {
+			return err
+		}
+		return fn()
+	}
+}
+
 func NewTaskCMD() *cobra.Command {
+	//deprecated flags kept so that scripts written against the old interface keep working
 	var create, build, disable, enable, delete, restart, get, force, resolve bool
-	var logs, code, vim, nano bool
+	var logs, code, vim, nano, follow bool
 	var name, description string
 	taskCMD := cobra.Command{
 		Use:   "task",
 		Short: "keiji task management",
 		Long:  "cobra commands to create, update, deploy, or delete tasks",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			var taskError error
-			if err := checkWorkSpace(); err != nil {
-				logError(err)
-				return nil
-			}
-			if !valid(name) && !get {
-				logError(fmt.Errorf("please provide name for your task"))
-				return nil
+			actions := map[string]bool{
+				"create":  create,
+				"build":   build,
+				"disable": disable,
+				"enable":  enable,
+				"get":     get,
+				"delete":  delete,
+				"resolve": resolve,
+				"logs":    logs,
+				//--restart used to be a modifier of --build
+				"restart": restart && !build,
 			}
-			if create {
-				if !valid(description) {
-					taskError = fmt.Errorf("please provide a description for your task")
-				} else {
-					taskError = createTask(name, description, force)
+			selected := make([]string, 0)
+			for action, ok := range actions {
+				if ok {
+					selected = append(selected, action)
 				}
-			} else if disable {
-				taskError = disableTask(name)
-			} else if enable {
-				taskError = enableTask(name)
-			} else if get {
-				taskError = getTask(name)
-			} else if delete {
-				taskError = deleteTask(name)
-			} else if build {
-				taskError = buildTask(name, restart)
-			} else if resolve {
-				taskError = resolveError(name)
-			} else if logs {
-				taskError = handleGetTaskLogs(name, code, vim, nano)
-			} else {
-				return fmt.Errorf("please pass a valid command")
 			}
-			if taskError != nil {
-				logError(taskError)
+			if len(selected) == 0 {
+				return cmd.Help()
 			}
-			return nil
+			if len(selected) > 1 {
+				sort.Strings(selected)
+				return fmt.Errorf("conflicting flags provided: --%s", strings.Join(selected, ", --"))
+			}
+			if !valid(name) && !get {
+				return fmt.Errorf("please provide name for your task")
+			}
+			return taskAction(func() error {
+				switch selected[0] {
+				case "create":
+					if !valid(description) {
+						return fmt.Errorf("please provide a description for your task")
+					}
+					return createTask(name, description, CreateOptions{Force: force})
+				case "build":
+					return buildTask(name, restart)
+				case "disable":
+					return disableTask(name)
+				case "enable":
+					return enableTask(name)
+				case "get":
+					return getTask(name)
+				case "delete":
+					return deleteTask(name)
+				case "resolve":
+					return resolveError(name)
+				case "logs":
+					return handleGetTaskLogs(name, code, vim, nano, follow)
+				default:
+					return restartTask(name)
+				}
+			})(cmd, args)
 		},
 	}
 	taskCMD.Flags().StringVar(&name, "name", "", "provid a name for your task")
@@ -580,9 +615,240 @@ func NewTaskCMD() *cobra.Command {
 	taskCMD.Flags().BoolVar(&code, "code", false, "opens service logs in vscode")
 	taskCMD.Flags().BoolVar(&vim, "vim", false, "opens service logs in vim")
 	taskCMD.Flags().BoolVar(&nano, "nano", false, "opens service logs in nano")
+	taskCMD.Flags().BoolVar(&follow, "follow", false, "keep printing new log lines until interrupted")
+	for _, action := range []string{"create", "build", "disable", "enable", "delete", "restart", "get", "resolve", "logs"} {
+		taskCMD.Flags().MarkDeprecated(action, fmt.Sprintf("use `keiji task %s` instead", action))
+	}
+	for _, modifier := range []string{"name", "desc", "force", "code", "vim", "nano", "follow"} {
+		taskCMD.Flags().MarkHidden(modifier)
+	}
+	taskCMD.AddCommand(
+		newTaskCreateCMD(),
+		newTaskBuildCMD(),
+		newTaskEnableCMD(),
+		newTaskDisableCMD(),
+		newTaskDeleteCMD(),
+		newTaskResolveCMD(),
+		newTaskLogsCMD(),
+		newTaskGetCMD(),
+		newTaskRestartCMD(),
+		newTaskListCMD(),
+		newTaskRunCMD(),
+		newTaskNextCMD(),
+		newTaskHistoryCMD(),
+		newTaskGraphCMD(),
+		newTaskTagCMD(),
+		newTaskCloneCMD(),
+		newTaskRenameCMD(),
+		newTaskEnvCMD(),
+	)
 	return &taskCMD
 }
-func createTask(name string, description string, force bool) error {
+
+func newTaskCreateCMD() *cobra.Command {
+	var name, description string
+	var opts CreateOptions
+	cmd := &cobra.Command{
+		Use:   "create NAME --desc=DESCRIPTION | --example=EXAMPLE",
+		Short: "create a new task",
+		Long: "scaffolds a new task in the workspace from the keiji-core task template, or a template registered with `keiji template add`.\n" +
+			"With --cron a //keiji:cron directive is added to schedule.go, the task then runs at the fire times of the\n" +
+			"expression and its HMS schedule sets how often the expression is polled, at most every minute",
+		Example: "keiji task create ping_google --desc=\"pings google\"\n" +
+			"keiji task create report --desc=\"office hours report\" --cron=\"0 */15 9-17 * * MON-FRI\"\n" +
+			"keiji task create invoices --desc=\"sends invoices\" --tags=billing,nightly\n" +
+			"keiji task create api_health --desc=\"checks the api\" --template=http-check\n" +
+			"keiji task create --example=ping_google",
+		Args: func(cmd *cobra.Command, args []string) error {
+			//examples are named after themselves unless a name is provided
+			if len(args) == 0 && !valid(name) && valid(opts.Example) {
+				name = opts.Example
+			}
+			return taskNameArgs(&name)(cmd, args)
+		},
+		RunE: taskAction(func() error {
+			return createTask(name, description, opts)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().StringVar(&description, "desc", "", "description of the task, required unless --example is provided")
+	cmd.Flags().StringVar(&opts.Cron, "cron", "", "cron expression with 5 fields, or 6 with leading seconds")
+	cmd.Flags().StringSliceVar(&opts.Tags, "tags", nil, "comma separated tags of the task")
+	cmd.Flags().StringVar(&opts.Template, "template", DefaultTemplate, "name of the template to create the task from, see `keiji template list`")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "overwrite the task folder if it already exists")
+	cmd.Flags().StringVar(&opts.Example, "example", "", fmt.Sprintf("name of an example task to copy: %v", strings.Join(exampleNames(), ", ")))
+	cmd.MarkFlagsMutuallyExclusive("template", "example")
+	return cmd
+}
+
+func newTaskBuildCMD() *cobra.Command {
+	sel := TaskSelector{Unbuilt: true}
+	var restart bool
+	cmd := &cobra.Command{
+		Use:     "build NAME | --tag=TAG | --all",
+		Short:   "build and save a task",
+		Long:    "compiles the task executable and saves its schedule to the database",
+		Example: "keiji task build ping_google --restart\nkeiji task build --tag=billing --yes",
+		Args:    sel.args,
+		RunE: taskAction(bulkAction("build", &sel, func(name string) error {
+			return buildTask(name, restart)
+		})),
+	}
+	sel.addFlags(cmd)
+	cmd.Flags().BoolVar(&restart, "restart", false, "restart the task after building it")
+	return cmd
+}
+
+func newTaskEnableCMD() *cobra.Command {
+	var sel TaskSelector
+	cmd := &cobra.Command{
+		Use:   "enable NAME | --tag=TAG | --all",
+		Short: "enable a disabled task",
+		Long:  "sets task.IsDisabled to false so that the scheduler picks the task up again",
+		Args:  sel.args,
+		RunE:  taskAction(bulkAction("enable", &sel, enableTask)),
+	}
+	sel.addFlags(cmd)
+	return cmd
+}
+
+func newTaskDisableCMD() *cobra.Command {
+	var sel TaskSelector
+	cmd := &cobra.Command{
+		Use:     "disable NAME | --tag=TAG | --all",
+		Short:   "disable a task",
+		Example: "keiji task disable ping_google\nkeiji task disable --tag=billing",
+		Long:    "stops the task and marks it as disabled so that the scheduler ignores it",
+		Args:    sel.args,
+		RunE:    taskAction(bulkAction("disable", &sel, disableTask)),
+	}
+	sel.addFlags(cmd)
+	return cmd
+}
+
+func newTaskDeleteCMD() *cobra.Command {
+	var sel TaskSelector
+	cmd := &cobra.Command{
+		Use:   "delete NAME | --tag=TAG | --all",
+		Short: "delete a task",
+		Long:  "removes the task executable, logs and database record",
+		Args:  sel.args,
+		RunE:  taskAction(bulkAction("delete", &sel, deleteTask)),
+	}
+	sel.addFlags(cmd)
+	return cmd
+}
+
+func newTaskResolveCMD() *cobra.Command {
+	var sel TaskSelector
+	cmd := &cobra.Command{
+		Use:   "resolve NAME | --tag=TAG | --all",
+		Short: "resolve a task error",
+		Long:  "sets task.IsError to false so that the scheduler picks the task up again",
+		Args:  sel.args,
+		RunE:  taskAction(bulkAction("resolve", &sel, resolveError)),
+	}
+	sel.addFlags(cmd)
+	return cmd
+}
+
+func newTaskLogsCMD() *cobra.Command {
+	var name string
+	var code, vim, nano, follow bool
+	cmd := &cobra.Command{
+		Use:     "logs NAME",
+		Short:   "view task logs",
+		Long:    "prints the last 100 log lines for a task, follows new lines or opens its log file in an editor",
+		Example: "keiji task logs ping_google --vim\nkeiji task logs ping_google --follow",
+		Args:    taskNameArgs(&name),
+		RunE: taskAction(func() error {
+			return handleGetTaskLogs(name, code, vim, nano, follow)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().BoolVar(&code, "code", false, "opens task logs in vscode")
+	cmd.Flags().BoolVar(&vim, "vim", false, "opens task logs in vim")
+	cmd.Flags().BoolVar(&nano, "nano", false, "opens task logs in nano")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing new log lines until interrupted")
+	cmd.MarkFlagsMutuallyExclusive("code", "vim", "nano", "follow")
+	return cmd
+}
+
+func newTaskGetCMD() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "get [NAME]",
+		Short: "get task info",
+		Long:  "prints task details, returns all tasks if name is not provided",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				return nil
+			}
+			return taskNameArgs(&name)(cmd, args)
+		},
+		RunE: taskAction(func() error {
+			return getTask(name)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	return cmd
+}
+
+func newTaskRestartCMD() *cobra.Command {
+	var sel TaskSelector
+	cmd := &cobra.Command{
+		Use:   "restart NAME | --tag=TAG | --all",
+		Short: "restart a running task",
+		Long:  "signals the scheduler to stop the task so that it is restarted with its latest executable",
+		Args:  sel.args,
+		RunE:  taskAction(bulkAction("restart", &sel, restartTask)),
+	}
+	sel.addFlags(cmd)
+	return cmd
+}
+
+/*
+CreateOptions are the optional settings of a new task
+*/
+type CreateOptions struct {
+	Cron string
+	Tags []string
+	//Template is the name of a template registered with `keiji template add`
+	Template string
+	//Example is the name of an example task to copy instead of a template
+	Example string
+	//Force overwrites the task folder if it already exists
+	Force bool
+}
+
+func createTask(name string, description string, opts CreateOptions) error {
+	if valid(opts.Cron) {
+		if _, err := runner.ParseCron(opts.Cron); err != nil {
+			return err
+		}
+	}
+	tags, err := parseTags(opts.Tags)
+	if err != nil {
+		return err
+	}
+	opts.Tags = tags
+	if valid(opts.Example) {
+		if valid(opts.Template) && opts.Template != DefaultTemplate {
+			return fmt.Errorf("--example and --template cannot be combined")
+		}
+		example, err := getExample(opts.Example)
+		if err != nil {
+			return err
+		}
+		if !valid(description) {
+			description = example.Description
+		}
+	} else if _, err := templatePath(opts.Template); err != nil {
+		return err
+	}
+	if !valid(description) {
+		return fmt.Errorf("please provide a description for your task")
+	}
 	//check if task exists
 	taskPath := filepath.Join(paths.TASKS_PATH, name)
 	exists, err := utils.PathExists(taskPath)
@@ -590,7 +856,7 @@ func createTask(name string, description string, force bool) error {
 		return err
 	}
 	if exists {
-		if force {
+		if opts.Force {
 			//delete task folder
 			err := os.RemoveAll(taskPath)
 			if err != nil {
@@ -607,22 +873,23 @@ func createTask(name string, description string, force bool) error {
 	if err != nil {
 		return err
 	}
-	repoPath, err := getTemplateRepoPath(false)
+	if valid(opts.Example) {
+		err = copyExample(opts.Example, taskPath)
+	} else {
+		err = renderTemplate(opts.Template, taskPath, TemplateData{Name: name, Description: description})
+	}
 	if err != nil {
+		os.RemoveAll(taskPath)
 		return err
 	}
-	//create destination folder
-	err = filepath.WalkDir(repoPath, func(path string, d fs.DirEntry, err error) error {
-		if d.Name() == "templates" {
-			return utils.CopyDir(filepath.Join(path, "tasks"), taskPath, 0644)
+	if valid(opts.Cron) {
+		if err := addCronDirective(name, opts.Cron); err != nil {
+			os.RemoveAll(taskPath)
+			return err
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
-	err = writeEnvFile(taskPath, name, description)
+	err = writeEnvFile(name, description, opts)
 	if err != nil {
 		return err
 	}
@@ -637,22 +904,30 @@ func createTask(name string, description string, force bool) error {
 
 func enableTask(name string) error {
 	_, err := cmdRepo.SetIsDisabled(name, false)
-	return err
+	return taskError(name, err)
 }
 
 func disableTask(name string) error {
-	task, err := cmdRepo.GetTaskByName(name)
+	task, err := getTaskByName(name)
 	if err != nil {
 		return err
 	}
-	return bc.StopTask(task.TaskId, true, false)
+	return stopTask(task.TaskId, true, false)
 }
 
 func deleteTask(name string) error {
-	task, err := cmdRepo.GetTaskByName(name)
+	task, err := getTaskByName(name)
 	if err != nil {
 		return err
 	}
+	//downstream tasks would wait forever for a deleted upstream task
+	graph, err := loadTaskGraph()
+	if err != nil {
+		return err
+	}
+	if downstream := graph.Downstream(task.Name); len(downstream) > 0 {
+		return fmt.Errorf("task %v is a dependency of %v, remove it from their //keiji:depends-on directive and rebuild them, or delete them first", task.Name, strings.Join(downstream, ", "))
+	}
 	/**check if task is disabled or inError state, if so
 	we can delete without sending a message to the scheduler
 	because such tasks are ignored by the scheduler
@@ -666,45 +941,94 @@ func deleteTask(name string) error {
 		if err != nil {
 			return err
 		}
-		return cmdRepo.DeleteTask(task)
+		err = cmdRepo.DeleteTask(task)
+	} else {
+		err = stopTask(task.TaskId, false, true)
+	}
+	if err != nil {
+		return err
+	}
+	if err := moveSecrets(task.Name, ""); err != nil {
+		return err
 	}
-	return bc.StopTask(task.TaskId, false, true)
+	return deleteTaskData(task.Name)
 }
 
 func restartTask(name string) error {
 	logWarn(fmt.Sprintf("restarting task %v\n", name))
-	task, err := cmdRepo.GetTaskByName(name)
+	task, err := getTaskByName(name)
 	if err != nil {
 		return err
 	}
-	return bc.StopTask(task.TaskId, false, false)
+	return stopTask(task.TaskId, false, false)
 }
 
 func resolveError(name string) error {
 	model, err := cmdRepo.SetIsError(name, false, "")
 	log.Printf("resolved : %v, %v\n", model, err)
+	return taskError(name, err)
+}
+
+/*
+getTaskByName returns the task named name, translating a missing
+database record into cmdErrors.TaskNotFound
+*/
+func getTaskByName(name string) (*db.TaskModel, error) {
+	task, err := cmdRepo.GetTaskByName(name)
+	if err != nil {
+		return nil, taskError(name, err)
+	}
+	return task, nil
+}
+
+/*
+findTask returns the task named name, nil if it has no database record.
+Find is used instead of First so that a missing record is not logged as an error
+*/
+func findTask(name string) (*db.TaskModel, error) {
+	tasks := make([]*db.TaskModel, 0, 1)
+	if err := cmdRepo.DB.Where("name = ?", name).Limit(1).Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	if len(tasks) == 0 {
+		return nil, nil
+	}
+	return tasks[0], nil
+}
+
+/*
+taskError translates repo errors for the task named name into typed errors
+*/
+func taskError(name string, err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return cmdErrors.ErrTaskNotFound(name)
+	}
 	return err
 }
 
+/*
+stopTask sends a stop, disable or delete message for the task to the scheduler via the bus
+*/
+func stopTask(taskId string, disable bool, delete bool) error {
+	if err := bc.StopTask(taskId, disable, delete); err != nil {
+		return cmdErrors.ErrBusUnreachable(err)
+	}
+	return nil
+}
+
 func getTask(name string) error {
 	if valid(name) {
-		task, err := cmdRepo.GetTaskByName(name)
-		if err != nil {
-			return err
-		}
-		fmt.Println(task)
-	} else {
-		tasks, err := cmdRepo.GetAllTasks()
+		task, err := getTaskByName(name)
 		if err != nil {
 			return err
 		}
-		for _, task := range tasks {
-			fmt.Println(strings.Repeat("=", 100))
-			fmt.Println(task)
-			fmt.Println(strings.Repeat("=", 100))
-		}
+		return printTasks([]*db.TaskModel{task})
 	}
-	return nil
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return err
+	}
+	return printTasks(tasks)
 }
 
 func buildTask(name string, restart bool) error {
@@ -715,12 +1039,46 @@ func buildTask(name string, restart bool) error {
 	}
 
 	if !exists {
-		return common.ErrPathNotFound(taskPath)
+		return cmdErrors.ErrTaskNotFound(name)
+	}
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
+	}
+	if expr, ok := env[legacyCronEnvKey]; ok {
+		return cmdErrors.ErrBuildFailed(name, fmt.Errorf("%v is no longer read, remove it from .env and declare `%vcron %v` above func Schedule in schedule.go", legacyCronEnvKey, directivePrefix, expr))
+	}
+	settings, err := readTaskSettings(name)
+	if err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
+	}
+	if err := checkDependencies(settings); err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
+	}
+	if err := installRunner(); err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
+	}
+	if err := writeRunnerFile(name); err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
 	}
 	logInfo("task found , building...")
 	err = runCMD(taskPath, false, "go", "run", "main.go", "schedule.go", "function.go", "--schedule")
 	if err != nil {
-		return err
+		return cmdErrors.ErrBuildFailed(name, err)
+	}
+	if valid(settings.Cron) {
+		task, err := getTaskByName(name)
+		if err != nil {
+			return cmdErrors.ErrBuildFailed(name, err)
+		}
+		if err := checkCronPoll(task, settings.Cron); err != nil {
+			//the scheduler skips tasks in error state until they are rebuilt
+			cmdRepo.SetIsError(name, true, err.Error())
+			return cmdErrors.ErrBuildFailed(name, err)
+		}
+	}
+	if err := saveTaskSettings(settings); err != nil {
+		return cmdErrors.ErrBuildFailed(name, err)
 	}
 	if restart {
 		return restartTask(name)
@@ -728,104 +1086,139 @@ func buildTask(name string, restart bool) error {
 	return nil
 }
 
+/*
+serviceNames returns the names of all services managed by keiji
+*/
+func serviceNames() []string {
+	names := make([]string, 0, len(c.SERVICES))
+	for _, service := range c.SERVICES {
+		names = append(names, string(service))
+	}
+	return names
+}
+
+/*
+resolveServices maps the service arguments of a system command onto services.
+No argument or `all` selects every service in c.SERVICES
+*/
+func resolveServices(args []string) ([]c.Service, error) {
+	if len(args) == 0 || (len(args) == 1 && args[0] == "all") {
+		return c.SERVICES, nil
+	}
+	services := make([]c.Service, 0, len(args))
+	for _, arg := range args {
+		found := false
+		for _, service := range c.SERVICES {
+			if string(service) == arg {
+				services = append(services, service)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("invalid service %q, valid services: %s, all", arg, strings.Join(serviceNames(), ", "))
+		}
+	}
+	return services, nil
+}
+
+/*
+serviceArgs validates the optional service argument of a system command
+*/
+func serviceArgs(cmd *cobra.Command, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("expected at most one service, got %d: %v", len(args), args)
+	}
+	_, err := resolveServices(args)
+	return err
+}
+
+/*
+systemAction wraps a service operation so that workspace validation
+behaves the same across all system subcommands. fn is called once for
+every service selected by the command's arguments
+*/
+func systemAction(fn func(service c.Service) error) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if err := checkWorkSpace(); err != nil {
+			return err
+		}
+		services, err := resolveServices(args)
+		if err != nil {
+			return err
+		}
+		for _, service := range services {
+			if err := fn(service); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 func NewSystemCMD() *cobra.Command {
-	//start stop update system services
+	//deprecated flags kept so that scripts written against the old interface keep working
 	var start, stop, logs, update, uninstall, cc bool
 	var scheduler, bus, status, restart bool
-	var code, vim, nano bool
+	var code, vim, nano, follow bool
 	systemCMD := cobra.Command{
 		Use:   "system",
 		Short: "manage system services",
 		Long:  "commands start, stop and diagnose system services",
+		Args:  cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-
-			if uninstall {
-				//first stop the system
-				err := stopAllServices()
-				if err != nil {
-					logError(err)
-				}
-				//uninstalls all services
-				err = uninstallSystem()
-				if err != nil {
-					logError(err)
+			actions := map[string]bool{
+				"start":     start,
+				"stop":      stop,
+				"logs":      logs,
+				"update":    update,
+				"uninstall": uninstall,
+				"status":    status,
+				"restart":   restart,
+			}
+			selected := make([]string, 0)
+			for action, ok := range actions {
+				if ok {
+					selected = append(selected, action)
 				}
-				return nil
 			}
-			if err := checkWorkSpace(); err != nil {
-				logError(err)
-				return nil
+			if len(selected) == 0 {
+				return cmd.Help()
 			}
-			if start {
-				var startError error
-				if scheduler {
-					startError = startService(c.SCHEDULER)
-				} else if bus {
-					startError = startService(c.TCP_BUS)
-				} else {
-					startError = startAllServices()
-				}
-				if startError != nil {
-					logError(startError)
-				}
-				return nil
-			} else if stop {
-				var stopError error
-				if scheduler {
-					stopError = stopService(c.SCHEDULER)
-				} else if bus {
-					stopError = stopService(c.TCP_BUS)
-				} else {
-					stopError = stopAllServices()
-				}
-				if stopError != nil {
-					logError(stopError)
-				}
-				return nil
-			} else if logs {
-				var logsError error
-				if scheduler {
-					logsError = handleGetServiceLogs(c.SCHEDULER, code, vim, nano)
-				} else if bus {
-					logsError = handleGetServiceLogs(c.TCP_BUS, code, vim, nano)
-				} else {
+			if len(selected) > 1 {
+				sort.Strings(selected)
+				return fmt.Errorf("conflicting flags provided: --%s", strings.Join(selected, ", --"))
+			}
+			if scheduler && bus {
+				return fmt.Errorf("conflicting flags provided: --bus, --scheduler")
+			}
+			targets := []string{}
+			if scheduler {
+				targets = append(targets, string(c.SCHEDULER))
+			} else if bus {
+				targets = append(targets, string(c.TCP_BUS))
+			}
+			switch selected[0] {
+			case "uninstall":
+				return uninstallAction(cmd, args)
+			case "status":
+				return statusAction(cmd, args)
+			case "logs":
+				if len(targets) == 0 {
 					return fmt.Errorf("no flag provided")
 				}
-				if logsError != nil {
-					logError(logsError)
-				}
-				return nil
-			} else if update {
-				var updateError error
-				if scheduler {
-					updateError = InstallService(c.SCHEDULER, update, cc)
-				} else if bus {
-					updateError = InstallService(c.TCP_BUS, update, cc)
-				} else {
-					updateError = installAllServices(update, cc)
-				}
-				if updateError != nil {
-					logError(updateError)
-				}
-				return nil
-			} else if status {
-				getServiceInfo()
-				return nil
-			} else if restart {
-				var restartError error
-				if scheduler {
-					restartError = restartService(c.SCHEDULER)
-				} else if bus {
-					restartError = restartService(c.TCP_BUS)
-				} else {
-					restartError = restartAllServices()
-				}
-				if restartError != nil {
-					logError(restartError)
-				}
-				return nil
+				return systemAction(func(service c.Service) error {
+					return handleGetServiceLogs(service, code, vim, nano, follow)
+				})(cmd, targets)
+			case "update":
+				return updateAction(cc)(cmd, targets)
+			case "start":
+				return systemAction(startService)(cmd, targets)
+			case "stop":
+				return systemAction(stopService)(cmd, targets)
+			default:
+				return systemAction(restartService)(cmd, targets)
 			}
-			return fmt.Errorf("no flag provided")
 		},
 	}
 	systemCMD.Flags().BoolVar(&scheduler, "scheduler", false, "manage scheduler service")
@@ -836,33 +1229,232 @@ func NewSystemCMD() *cobra.Command {
 	systemCMD.Flags().BoolVar(&code, "code", false, "opens service logs in vscode")
 	systemCMD.Flags().BoolVar(&vim, "vim", false, "opens service logs in vim")
 	systemCMD.Flags().BoolVar(&nano, "nano", false, "opens service logs in nano")
+	systemCMD.Flags().BoolVar(&follow, "follow", false, "keep printing new log lines until interrupted")
 	systemCMD.Flags().BoolVar(&update, "update", false, "updates service is specified otherwise all")
 	systemCMD.Flags().BoolVar(&uninstall, "uninstall", false, "uinstalls all services and packages")
 	systemCMD.Flags().BoolVar(&status, "status", false, "get status of system services")
 	systemCMD.Flags().BoolVar(&restart, "restart", false, "restart all services")
 	systemCMD.Flags().BoolVar(&cc, "cc", false, "clears go mod cache")
+	for _, action := range []string{"start", "stop", "logs", "update", "uninstall", "status", "restart"} {
+		systemCMD.Flags().MarkDeprecated(action, fmt.Sprintf("use `keiji system %s` instead", action))
+	}
+	for _, selector := range []string{"scheduler", "bus"} {
+		systemCMD.Flags().MarkDeprecated(selector, fmt.Sprintf("pass the service as an argument e.g `keiji system start %s`", selector))
+	}
+	for _, modifier := range []string{"code", "vim", "nano", "follow", "cc"} {
+		systemCMD.Flags().MarkHidden(modifier)
+	}
+	systemCMD.AddCommand(
+		newSystemStartCMD(),
+		newSystemStopCMD(),
+		newSystemRestartCMD(),
+		newSystemUpdateCMD(),
+		newSystemLogsCMD(),
+		newSystemStatusCMD(),
+		newSystemUninstallCMD(),
+		newSystemInstallUnitsCMD(),
+		newSystemRemoveUnitsCMD(),
+		newSystemSuperviseCMD(),
+	)
 	return &systemCMD
 }
 
+/*
+serviceUse builds the usage line of a system subcommand that accepts an
+optional service argument
+*/
+func serviceUse(action string) string {
+	return fmt.Sprintf("%s [%s|all]", action, strings.Join(serviceNames(), "|"))
+}
+
+func newSystemStartCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:       serviceUse("start"),
+		Short:     "start system services",
+		Long:      "starts the given service, or all services if none is provided",
+		Example:   "keiji system start\nkeiji system start scheduler",
+		ValidArgs: append(serviceNames(), "all"),
+		Args:      serviceArgs,
+		RunE:      systemAction(startService),
+	}
+}
+
+func newSystemStopCMD() *cobra.Command {
+	opts := StopOptions{}
+	cmd := &cobra.Command{
+		Use:   serviceUse("stop"),
+		Short: "stop system services",
+		Long: "stops the given service, or all services if none is provided. Services are sent SIGINT and given --timeout\n" +
+			"to exit along with the tasks they are running, --force then sends SIGTERM and SIGKILL.\n" +
+			"Services managed by systemd units are stopped by systemctl, --timeout and --force cannot be used with them",
+		Example:   "keiji system stop\nkeiji system stop bus\nkeiji system stop scheduler --timeout=60s --force",
+		ValidArgs: append(serviceNames(), "all"),
+		Args:      serviceArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.Timeout <= 0 {
+				return fmt.Errorf("invalid --timeout %v, must be positive", opts.Timeout)
+			}
+			//systemctl stop applies the TimeoutStopSec of the unit then kills its processes
+			if valid(installedUnits()) && (cmd.Flags().Changed("timeout") || cmd.Flags().Changed("force")) {
+				return fmt.Errorf("--timeout and --force cannot be used with services managed by systemd units, " +
+					"systemctl stops them using the unit's TimeoutStopSec. Remove the units with `keiji system remove-units` to drain services with keiji")
+			}
+			return systemAction(func(service c.Service) error {
+				return stopServiceWith(service, opts)
+			})(cmd, args)
+		},
+	}
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", maxRetries*retryInterval, "how long services and their running tasks are given to exit")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "send SIGTERM then SIGKILL to the processes still running after --timeout")
+	return cmd
+}
+
+func newSystemRestartCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:       serviceUse("restart"),
+		Short:     "restart system services",
+		Long:      "restarts the given service, or all services if none is provided",
+		ValidArgs: append(serviceNames(), "all"),
+		Args:      serviceArgs,
+		RunE:      systemAction(restartService),
+	}
+}
+
+/*
+updateAction reinstalls the selected services, clearing the go mod cache
+once beforehand when cc is true
+*/
+func updateAction(cc bool) func(cmd *cobra.Command, args []string) error {
+	return func(cmd *cobra.Command, args []string) error {
+		if cc {
+			if err := clearCache(); err != nil {
+				return err
+			}
+		}
+		return systemAction(func(service c.Service) error {
+			return InstallService(service, true, false)
+		})(cmd, args)
+	}
+}
+
+func newSystemUpdateCMD() *cobra.Command {
+	var cc bool
+	cmd := &cobra.Command{
+		Use:       serviceUse("update"),
+		Short:     "update system services",
+		Long:      "reinstalls the latest version of the given service, or all services if none is provided",
+		ValidArgs: append(serviceNames(), "all"),
+		Args:      serviceArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return updateAction(cc)(cmd, args)
+		},
+	}
+	cmd.Flags().BoolVar(&cc, "cc", false, "clears go mod cache before updating")
+	return cmd
+}
+
+func newSystemLogsCMD() *cobra.Command {
+	var code, vim, nano, follow bool
+	cmd := &cobra.Command{
+		Use:       fmt.Sprintf("logs %s", strings.Join(serviceNames(), "|")),
+		Short:     "view service logs",
+		Long:      "prints the last 100 log lines for a service, follows new lines or opens its log file in an editor",
+		Example:   "keiji system logs bus\nkeiji system logs scheduler --vim\nkeiji system logs scheduler --follow",
+		ValidArgs: serviceNames(),
+		Args:      cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+		RunE: systemAction(func(service c.Service) error {
+			return handleGetServiceLogs(service, code, vim, nano, follow)
+		}),
+	}
+	cmd.Flags().BoolVar(&code, "code", false, "opens service logs in vscode")
+	cmd.Flags().BoolVar(&vim, "vim", false, "opens service logs in vim")
+	cmd.Flags().BoolVar(&nano, "nano", false, "opens service logs in nano")
+	cmd.Flags().BoolVarP(&follow, "follow", "f", false, "keep printing new log lines until interrupted")
+	cmd.MarkFlagsMutuallyExclusive("code", "vim", "nano", "follow")
+	return cmd
+}
+
+func statusAction(cmd *cobra.Command, args []string) error {
+	if err := checkWorkSpace(); err != nil {
+		return err
+	}
+	return getServiceInfo()
+}
+
+func newSystemStatusCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:   "status",
+		Short: "get status of system services",
+		Long: "reports the status, health, version, pid, uptime, memory & cpu usage of every service, followed by the number\n" +
+			"of tasks by state. -o wide adds the ports of the bus, log file, crashes and last log line of each service",
+		Args: cobra.NoArgs,
+		RunE: statusAction,
+	}
+}
+
+func uninstallAction(cmd *cobra.Command, args []string) error {
+	//first stop the system
+	if err := stopSupervisor(); err != nil {
+		logError(err)
+	}
+	err := stopAllServices()
+	if err != nil {
+		logError(err)
+	}
+	if err := removeUnits(); err != nil {
+		logError(err)
+	}
+	//uninstalls all services
+	return uninstallSystem()
+}
+
+func newSystemUninstallCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall",
+		Short: "uninstall keiji",
+		Long:  "stops and uninstalls all services, removes the workspace, system folder and keiji packages",
+		Args:  cobra.NoArgs,
+		RunE:  uninstallAction,
+	}
+}
+
 func getServiceLogPath(service c.Service) (string, error) {
-	switch service {
-	case c.SCHEDULER:
-		return paths.SCHEDULER_LOGS, nil
-	case c.TCP_BUS:
-		return paths.BUS_LOGS, nil
-	default:
+	logsPath, ok := serviceLogsMapping[service]
+	if !ok {
 		return "", fmt.Errorf("invalid service name %v", service)
 	}
+	return logsPath, nil
 }
 
 func startService(service c.Service) error {
+	installed, err := isServiceInstalled(service)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return cmdErrors.ErrServiceNotInstalled(service)
+	}
+	if managed, err := unitAction("start", service); managed {
+		return err
+	}
+	if supervised, err := supervisorStart(service); supervised {
+		return err
+	}
+	unlock, err := lockPIDFile(service)
+	if err != nil {
+		return err
+	}
+	defer unlock()
 	//check if service is running first
-	isRunning := isServiceRunning(service)
-	if isRunning {
+	process, err := removeStalePIDFile(service)
+	if err != nil {
+		return err
+	}
+	if process != nil && !valid(process.Stale) {
 		logWarn("service already running")
 		return nil
 	}
-	err := runServiceCMD(service)
+	err = runServiceCMD(service)
 	if err != nil {
 		return err
 	}
@@ -900,18 +1492,10 @@ func readPID(pidPath string) (int, error) {
 
 	return pid, nil
 }
-func restartAllServices() error {
-	logWarn("restarting all services")
-	for _, service := range c.SERVICES {
-		err := restartService(service)
-		if err != nil {
-			return err
-		}
-	}
-	logInfo("ok")
-	return nil
-}
 func restartService(service c.Service) error {
+	if managed, err := unitAction("restart", service); managed {
+		return err
+	}
 	isRunning := isServiceRunning(service)
 	if !isRunning {
 		logWarn("service is not running.")
@@ -924,51 +1508,25 @@ func restartService(service c.Service) error {
 	return startService(service)
 }
 func stopService(service c.Service) error {
-	//stop service using its pid
-	pidPath := paths.PID_PATH(service)
-	exists, err := utils.PathExists(pidPath)
-	if err != nil {
-		return fmt.Errorf("error retrieving pidPath: %v", err)
-	}
-	if !exists {
-		return fmt.Errorf("pid path not found for service: %v", service)
-	}
-	PID, err := readPID(pidPath)
-	if err != nil {
-		return fmt.Errorf("error reading service PID: %v", err)
-	}
-	err = syscall.Kill(PID, syscall.SIGINT)
-	if err != nil {
-		return fmt.Errorf("kill error: %v", err)
-	}
-
-	for c := 0; c < maxRetries; c++ {
-		r := isServiceRunning(service)
-		if !r {
-			logInfo(fmt.Sprintf("%s stopped successfully", service))
-			return nil
-		}
-		time.Sleep(retryInterval)
-	}
-	return fmt.Errorf("failed to stop service after %d retries, run ps aux to inspect", maxRetries)
+	return stopServiceWith(service, StopOptions{Timeout: maxRetries * retryInterval})
 }
 
-func handleGetTaskLogs(name string, code, vim, nano bool) error {
-	task, err := cmdRepo.GetTaskByName(name)
+func handleGetTaskLogs(name string, code, vim, nano, follow bool) error {
+	task, err := getTaskByName(name)
 	if err != nil {
 		return err
 	}
-	return handleGetLogs(task.LogPath, code, vim, nano)
+	return handleGetLogs(task.LogPath, code, vim, nano, follow)
 }
-func handleGetServiceLogs(service c.Service, code, vim, nano bool) error {
+func handleGetServiceLogs(service c.Service, code, vim, nano, follow bool) error {
 	path := serviceLogsMapping[service]
 	if valid(path) {
-		return handleGetLogs(path, code, vim, nano)
+		return handleGetLogs(path, code, vim, nano, follow)
 	}
 	return fmt.Errorf("logs path for service %v not found", service)
 }
 
-func handleGetLogs(path string, code, vim, nano bool) error {
+func handleGetLogs(path string, code, vim, nano, follow bool) error {
 	var editor Editor
 	if code {
 		editor = CODE
@@ -980,6 +1538,9 @@ func handleGetLogs(path string, code, vim, nano bool) error {
 		editor = NANO
 	}
 	if valid(editor) {
+		if follow {
+			return fmt.Errorf("--follow cannot be combined with --%s", editor)
+		}
 		return OpenInEditor(editor, path)
 	}
 	logsLines, err := utils.GetLogLines(path)
@@ -989,6 +1550,9 @@ func handleGetLogs(path string, code, vim, nano bool) error {
 	for _, line := range logsLines.Content {
 		fmt.Println(line)
 	}
+	if follow {
+		return followLogs(path)
+	}
 	return nil
 }
 
@@ -1023,15 +1587,6 @@ func OpenInEditor(editor Editor, path string) error {
 	return cmd.Run()
 }
 
-func startAllServices() error {
-	for _, service := range c.SERVICES {
-		err := startService(service)
-		if err != nil {
-			return err
-		}
-	}
-	return nil
-}
 func stopAllServices() error {
 	for _, service := range c.SERVICES {
 		err := stopService(service)
@@ -1079,7 +1634,12 @@ func runServiceCMD(service c.Service) error {
 	if err != nil {
 		return fmt.Errorf("failed to start command: %v", err)
 	}
-	return nil
+	pid, err := readPID(pidPath)
+	if err != nil {
+		return err
+	}
+	//the start time recorded next to the pid tells the service apart from a process reusing its pid
+	return writePIDFile(pidPath, pid)
 }
 
 func runCMD(targetDir string, silence bool, ss ...string) error {
@@ -1099,25 +1659,34 @@ func runCMD(targetDir string, silence bool, ss ...string) error {
 	return nil
 }
 
-func writeEnvFile(taskDir, task, description string) error {
-	envFilePath := filepath.Join(taskDir, ".env")
-	envFile, err := os.Create(envFilePath)
+/*
+writeEnvFile saves the name, description and options of a new task in its .env file,
+variables provided by the task template are kept
+*/
+func writeEnvFile(task, description string, opts CreateOptions) error {
+	env, err := readTaskEnv(task)
 	if err != nil {
 		return err
 	}
-	defer envFile.Close()
-	_, err = envFile.WriteString(fmt.Sprintf("TASK_NAME='%s'\nTASK_DESCRIPTION='%s'\n", task, description))
-	return err
+	env["TASK_NAME"] = task
+	env["TASK_DESCRIPTION"] = description
+	if len(opts.Tags) > 0 {
+		env[tagsEnvKey] = strings.Join(opts.Tags, ",")
+	}
+	return writeTaskEnv(task, env)
 }
 
+/*
+Execute runs the root command and exits with the code documented in
+cmdErrors for the returned error
+*/
 func Execute() {
-	defer func() {
-		if cmdRepo != nil {
-			cmdRepo.Close()
-		}
-	}()
 	err := rootCmd.Execute()
+	if cmdRepo != nil {
+		cmdRepo.Close()
+	}
 	if err != nil {
-		log.Fatal(err)
+		logError(err)
+		os.Exit(cmdErrors.ExitCode(err))
 	}
 }
@@ -0,0 +1,154 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// followInterval is how often a followed log file is polled for new content
+const followInterval = 500 * time.Millisecond
+
+/*
+followLogs streams lines appended to the log file at path to stdout
+until the user interrupts the command
+*/
+func followLogs(path string) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return tailFile(ctx, path, os.Stdout)
+}
+
+/*
+tailFile writes lines appended to path to w until ctx is done.
+keiji-core rotates logs by copying the file to path.<timestamp> and truncating it,
+so a shrinking file is read again from the start after the lines missed in the
+rotated copy have been written. If the file is replaced instead, the new file is reopened
+*/
+func tailFile(ctx context.Context, path string, w io.Writer) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+	}()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	reader := bufio.NewReader(f)
+	pending := ""
+	ticker := time.NewTicker(followInterval)
+	defer ticker.Stop()
+	for {
+		for {
+			line, err := reader.ReadString('\n')
+			offset += int64(len(line))
+			pending += line
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return err
+			}
+			if _, err := io.WriteString(w, pending); err != nil {
+				return err
+			}
+			pending = ""
+		}
+		select {
+		case <-ctx.Done():
+			if len(pending) > 0 {
+				_, err := io.WriteString(w, pending+"\n")
+				return err
+			}
+			return nil
+		case <-ticker.C:
+		}
+		info, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			//the file is being rotated, wait for it to be recreated
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		current, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) {
+			newFile, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			//drain whatever was written to the old file before switching
+			if _, err := io.WriteString(w, pending); err != nil {
+				return err
+			}
+			if _, err := io.Copy(w, reader); err != nil {
+				return err
+			}
+			f.Close()
+			f = newFile
+		} else if info.Size() < offset {
+			if _, err := io.WriteString(w, pending); err != nil {
+				return err
+			}
+			if err := writeRotatedTail(path, offset, w); err != nil {
+				return err
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+		} else {
+			continue
+		}
+		offset = 0
+		pending = ""
+		reader.Reset(f)
+	}
+}
+
+/*
+writeRotatedTail writes the content of the most recent rotated copy of path
+starting at offset, i.e the lines that were written after the last read
+but before the file was truncated
+*/
+func writeRotatedTail(path string, offset int64, w io.Writer) error {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return err
+	}
+	var latest string
+	var latestTime time.Time
+	for _, match := range matches {
+		info, err := os.Stat(match)
+		if err != nil || info.Size() < offset {
+			continue
+		}
+		if info.ModTime().After(latestTime) {
+			latest, latestTime = match, info.ModTime()
+		}
+	}
+	if !valid(latest) {
+		return nil
+	}
+	rotated, err := os.Open(latest)
+	if err != nil {
+		return err
+	}
+	defer rotated.Close()
+	if _, err := rotated.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, rotated)
+	return err
+}
@@ -0,0 +1,287 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+	"github.com/aodr3w/keiji/runner"
+	"github.com/spf13/cobra"
+)
+
+/*
+parseDependsOn parses the arguments of a depends-on directive
+e.g `extract transform` or `extract,transform`
+*/
+func parseDependsOn(name string, args string) ([]string, error) {
+	upstream := make([]string, 0)
+	for _, field := range strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if field == name {
+			return nil, fmt.Errorf("task %v cannot depend on itself", name)
+		}
+		if containsFold(upstream, field) {
+			continue
+		}
+		exists, err := utils.PathExists(filepath.Join(paths.TASKS_PATH, field))
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			return nil, fmt.Errorf("upstream task %v of %v does not exist", field, name)
+		}
+		upstream = append(upstream, field)
+	}
+	if len(upstream) == 0 {
+		return nil, fmt.Errorf("depends-on directive of %v lists no tasks", name)
+	}
+	return upstream, nil
+}
+
+/*
+TaskGraph maps every task onto the tasks it depends on
+*/
+type TaskGraph map[string][]string
+
+/*
+loadTaskGraph builds the dependency graph of all tasks from their stored settings
+*/
+func loadTaskGraph() (TaskGraph, error) {
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return nil, err
+	}
+	settings, err := getAllTaskSettings()
+	if err != nil {
+		return nil, err
+	}
+	graph := make(TaskGraph)
+	for _, task := range tasks {
+		graph[task.Name] = nil
+	}
+	for name, s := range settings {
+		if _, ok := graph[name]; ok {
+			graph[name] = s.DependsOn
+		}
+	}
+	return graph, nil
+}
+
+/*
+Downstream returns the tasks that depend on the task named name, sorted by name
+*/
+func (g TaskGraph) Downstream(name string) []string {
+	downstream := make([]string, 0)
+	for task, upstream := range g {
+		for _, u := range upstream {
+			if u == name {
+				downstream = append(downstream, task)
+			}
+		}
+	}
+	sort.Strings(downstream)
+	return downstream
+}
+
+/*
+Cycle returns the tasks forming a dependency cycle e.g [a b a], nil if the graph is acyclic
+*/
+func (g TaskGraph) Cycle() []string {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	stack := make([]string, 0)
+	var visit func(name string) []string
+	visit = func(name string) []string {
+		state[name] = visiting
+		stack = append(stack, name)
+		for _, upstream := range g[name] {
+			switch state[upstream] {
+			case visiting:
+				for i, n := range stack {
+					if n == upstream {
+						return append(append([]string{}, stack[i:]...), upstream)
+					}
+				}
+			case unvisited:
+				if cycle := visit(upstream); cycle != nil {
+					return cycle
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = visited
+		return nil
+	}
+	names := make([]string, 0, len(g))
+	for name := range g {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if state[name] == unvisited {
+			if cycle := visit(name); cycle != nil {
+				return cycle
+			}
+		}
+	}
+	return nil
+}
+
+/*
+checkDependencies returns an error if saving settings would introduce a dependency cycle
+*/
+func checkDependencies(settings *TaskSettingsModel) error {
+	graph, err := loadTaskGraph()
+	if err != nil {
+		return err
+	}
+	graph[settings.TaskName] = settings.DependsOn
+	if cycle := graph.Cycle(); cycle != nil {
+		return fmt.Errorf("dependency cycle detected: %v", strings.Join(cycle, " -> "))
+	}
+	return nil
+}
+
+/*
+runDownstream runs the tasks depending on the task named name whose upstream
+tasks have all succeeded. Failures of independent branches are returned together.
+Manual runs start their downstream tasks here so that their output is streamed,
+scheduled runs are handled by the runner
+*/
+func runDownstream(name string) error {
+	graph, err := loadTaskGraph()
+	if err != nil {
+		return err
+	}
+	errs := make([]error, 0)
+	for _, downstream := range graph.Downstream(name) {
+		claimed, err := runner.ClaimDownstream(cmdRepo, downstream, graph[downstream])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if !claimed {
+			logWarn(fmt.Sprintf("skipping %v, waiting for its other upstream tasks %v or already started by one of them", downstream, graph[downstream]))
+			continue
+		}
+		task, err := getTaskByName(downstream)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := checkRunnable(task, &RunOptions{}); err != nil {
+			logWarn(fmt.Sprintf("skipping %v: %v", downstream, err))
+			continue
+		}
+		if err := runTask(downstream, RunOptions{Trigger: runner.TriggerUpstream}); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+TaskNode is the serialized representation of a task in the dependency graph
+*/
+type TaskNode struct {
+	Name       string   `json:"name" yaml:"name"`
+	DependsOn  []string `json:"dependsOn" yaml:"dependsOn"`
+	Downstream []string `json:"downstream" yaml:"downstream"`
+}
+
+/*
+printTaskGraph prints the dependency graph as trees rooted at tasks without
+upstream tasks, or the tree rooted at the task named name
+*/
+func printTaskGraph(name string) error {
+	graph, err := loadTaskGraph()
+	if err != nil {
+		return err
+	}
+	if cycle := graph.Cycle(); cycle != nil {
+		return fmt.Errorf("dependency cycle detected: %v", strings.Join(cycle, " -> "))
+	}
+	roots := make([]string, 0)
+	if valid(name) {
+		if _, ok := graph[name]; !ok {
+			_, err := getTaskByName(name)
+			return err
+		}
+		roots = append(roots, name)
+	} else {
+		for task, upstream := range graph {
+			if len(upstream) == 0 {
+				roots = append(roots, task)
+			}
+		}
+		sort.Strings(roots)
+	}
+	nodes := make([]TaskNode, 0)
+	seen := make(map[string]bool)
+	var collect func(task string)
+	collect = func(task string) {
+		if seen[task] {
+			return
+		}
+		seen[task] = true
+		nodes = append(nodes, TaskNode{Name: task, DependsOn: append([]string{}, graph[task]...), Downstream: graph.Downstream(task)})
+		for _, d := range graph.Downstream(task) {
+			collect(d)
+		}
+	}
+	for _, root := range roots {
+		collect(root)
+	}
+	return printOutput("TaskGraph", nodes, func(w io.Writer, wide bool) {
+		var printTree func(task string, prefix string)
+		printTree = func(task string, prefix string) {
+			downstream := graph.Downstream(task)
+			for i, d := range downstream {
+				branch, indent := "├── ", "│   "
+				if i == len(downstream)-1 {
+					branch, indent = "└── ", "    "
+				}
+				label := d
+				if len(graph[d]) > 1 {
+					label = fmt.Sprintf("%v (after %v)", d, strings.Join(graph[d], ", "))
+				}
+				fmt.Fprintln(w, prefix+branch+label)
+				printTree(d, prefix+indent)
+			}
+		}
+		for _, root := range roots {
+			fmt.Fprintln(w, root)
+			printTree(root, "")
+		}
+	})
+}
+
+func newTaskGraphCMD() *cobra.Command {
+	var name string
+	cmd := &cobra.Command{
+		Use:   "graph [NAME]",
+		Short: "print task dependencies",
+		Long: "prints the dependency graph of all tasks, or the tasks downstream of NAME.\n" +
+			"Dependencies are declared with a //keiji:depends-on directive in the task's schedule.go",
+		Example: "keiji task graph\nkeiji task graph extract -o json",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 && !valid(name) {
+				return nil
+			}
+			return taskNameArgs(&name)(cmd, args)
+		},
+		RunE: taskAction(func() error {
+			return printTaskGraph(name)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	return cmd
+}
@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTaskGraphCycle(t *testing.T) {
+	tests := []struct {
+		name  string
+		graph TaskGraph
+		want  []string
+	}{
+		{
+			name:  "empty",
+			graph: TaskGraph{},
+			want:  nil,
+		},
+		{
+			name:  "no dependencies",
+			graph: TaskGraph{"a": nil, "b": nil},
+			want:  nil,
+		},
+		{
+			name:  "pipeline",
+			graph: TaskGraph{"extract": nil, "transform": {"extract"}, "load": {"transform"}},
+			want:  nil,
+		},
+		{
+			name:  "diamond",
+			graph: TaskGraph{"a": nil, "b": {"a"}, "c": {"a"}, "d": {"b", "c"}},
+			want:  nil,
+		},
+		{
+			name:  "self dependency",
+			graph: TaskGraph{"a": {"a"}},
+			want:  []string{"a", "a"},
+		},
+		{
+			name:  "two tasks",
+			graph: TaskGraph{"a": {"b"}, "b": {"a"}},
+			want:  []string{"a", "b", "a"},
+		},
+		{
+			name:  "cycle behind an acyclic task",
+			graph: TaskGraph{"a": {"b"}, "b": {"c"}, "c": {"d"}, "d": {"b"}},
+			want:  []string{"b", "c", "d", "b"},
+		},
+		{
+			name:  "upstream task without settings",
+			graph: TaskGraph{"a": {"missing"}},
+			want:  nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.graph.Cycle(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Cycle() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTaskGraphDownstream(t *testing.T) {
+	graph := TaskGraph{"extract": nil, "transform": {"extract"}, "audit": {"extract"}, "load": {"transform"}}
+	tests := []struct {
+		task string
+		want []string
+	}{
+		{task: "extract", want: []string{"audit", "transform"}},
+		{task: "transform", want: []string{"load"}},
+		{task: "load", want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.task, func(t *testing.T) {
+			if got := graph.Downstream(tt.task); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Downstream(%v) = %v, want %v", tt.task, got, tt.want)
+			}
+		})
+	}
+}
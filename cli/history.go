@@ -0,0 +1,143 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji/runner"
+	"github.com/spf13/cobra"
+)
+
+var runStatuses = runner.Statuses
+
+/*
+HistoryQuery holds the criteria used by `keiji task history`
+*/
+type HistoryQuery struct {
+	Statuses []string
+	Page     int
+	PageSize int
+}
+
+func (q *HistoryQuery) validate() error {
+	if q.Page < 1 {
+		return fmt.Errorf("--page must be at least 1")
+	}
+	if q.PageSize < 1 {
+		return fmt.Errorf("--page-size must be at least 1")
+	}
+	for _, status := range q.Statuses {
+		if !containsFold(runStatuses, status) {
+			return fmt.Errorf("invalid status %q, valid statuses: %s", status, strings.Join(runStatuses, ", "))
+		}
+	}
+	return nil
+}
+
+/*
+TaskRunView is the serialized representation of a task run
+*/
+type TaskRunView struct {
+	Task      string    `json:"task" yaml:"task"`
+	Start     time.Time `json:"start" yaml:"start"`
+	End       time.Time `json:"end" yaml:"end"`
+	Duration  string    `json:"duration" yaml:"duration"`
+	ExitCode  int       `json:"exitCode" yaml:"exitCode"`
+	Attempt   int       `json:"attempt" yaml:"attempt"`
+	Status    string    `json:"status" yaml:"status"`
+	ErrorTxt  string    `json:"errorTxt,omitempty" yaml:"errorTxt,omitempty"`
+	LogOffset int64     `json:"logOffset" yaml:"logOffset"`
+	Trigger   string    `json:"trigger" yaml:"trigger"`
+	//FireTime is the fire time of the cron expression a scheduled run executed
+	FireTime *time.Time `json:"fireTime,omitempty" yaml:"fireTime,omitempty"`
+}
+
+func newTaskRunView(run *TaskRunModel) TaskRunView {
+	return TaskRunView{
+		Task:      run.TaskName,
+		Start:     run.Start,
+		End:       run.End,
+		Duration:  run.Duration.Truncate(time.Millisecond).String(),
+		ExitCode:  run.ExitCode,
+		Attempt:   run.Attempt,
+		Status:    run.Status,
+		ErrorTxt:  run.ErrorTxt,
+		LogOffset: run.LogOffset,
+		Trigger:   run.Trigger,
+		FireTime:  run.FireTime,
+	}
+}
+
+/*
+taskHistory prints a page of the runs of the task named name, most recent first
+*/
+func taskHistory(name string, query *HistoryQuery) error {
+	if err := query.validate(); err != nil {
+		return err
+	}
+	task, err := getTaskByName(name)
+	if err != nil {
+		return err
+	}
+	tx := cmdRepo.DB.Model(&TaskRunModel{}).Where("task_name = ?", task.Name)
+	if len(query.Statuses) > 0 {
+		statuses := make([]string, 0, len(query.Statuses))
+		for _, status := range query.Statuses {
+			statuses = append(statuses, strings.ToLower(status))
+		}
+		tx = tx.Where("status IN ?", statuses)
+	}
+	var total int64
+	if err := tx.Count(&total).Error; err != nil {
+		return err
+	}
+	runs := make([]*TaskRunModel, 0, query.PageSize)
+	err = tx.Order("start DESC").Offset((query.Page - 1) * query.PageSize).Limit(query.PageSize).Find(&runs).Error
+	if err != nil {
+		return err
+	}
+	views := make([]TaskRunView, 0, len(runs))
+	for _, run := range runs {
+		views = append(views, newTaskRunView(run))
+	}
+	pages := (total + int64(query.PageSize) - 1) / int64(query.PageSize)
+	return printOutput("TaskRunList", views, func(w io.Writer, wide bool) {
+		if wide {
+			fmt.Fprintln(w, "START\tEND\tDURATION\tSTATUS\tEXIT CODE\tATTEMPT\tTRIGGER\tLOG OFFSET\tERROR")
+		} else {
+			fmt.Fprintln(w, "START\tDURATION\tSTATUS\tEXIT CODE\tTRIGGER\tERROR")
+		}
+		for _, view := range views {
+			start := view.Start.Format(time.RFC3339)
+			if wide {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d\t%d\t%s\t%d\t%s\n", start, view.End.Format(time.RFC3339), view.Duration, view.Status, view.ExitCode, view.Attempt, view.Trigger, view.LogOffset, view.ErrorTxt)
+			} else {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\t%s\n", start, view.Duration, view.Status, view.ExitCode, view.Trigger, view.ErrorTxt)
+			}
+		}
+		fmt.Fprintf(w, "\npage %d of %d (%d runs)\n", query.Page, pages, total)
+	})
+}
+
+func newTaskHistoryCMD() *cobra.Command {
+	var name string
+	query := HistoryQuery{}
+	cmd := &cobra.Command{
+		Use:   "history NAME",
+		Short: "show past runs of a task",
+		Long:  "prints the recorded runs of a task, most recent first",
+		Example: "keiji task history ping_google\n" +
+			"keiji task history --name=ping_google --status=error --page=2 --page-size=10 -o json",
+		Args: taskNameArgs(&name),
+		RunE: taskAction(func() error {
+			return taskHistory(name, &query)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().StringSliceVar(&query.Statuses, "status", nil, "only show runs with these statuses: success, error, timeout, interrupted")
+	cmd.Flags().IntVar(&query.Page, "page", 1, "page to show, starting at 1")
+	cmd.Flags().IntVar(&query.PageSize, "page-size", 20, "number of runs per page")
+	return cmd
+}
@@ -0,0 +1,175 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/spf13/cobra"
+)
+
+var taskStates = []string{"idle", "running", "queued", "error", "disabled"}
+
+/*
+TaskFilter holds the criteria used to select tasks for `keiji task list`
+*/
+type TaskFilter struct {
+	States   []string
+	Types    []string
+	NameGlob string
+	Tags     []string
+}
+
+/*
+Match returns true if task satisfies every criteria of the filter
+*/
+func (f *TaskFilter) Match(task *db.TaskModel) (bool, error) {
+	if len(f.States) > 0 && !containsFold(f.States, taskState(task)) {
+		return false, nil
+	}
+	if len(f.Types) > 0 && !containsFold(f.Types, string(task.Type)) {
+		return false, nil
+	}
+	if len(f.Tags) > 0 {
+		tags, err := taskTags(task.Name)
+		if err != nil {
+			return false, err
+		}
+		tagged := false
+		for _, tag := range f.Tags {
+			tagged = tagged || containsFold(tags, tag)
+		}
+		if !tagged {
+			return false, nil
+		}
+	}
+	if valid(f.NameGlob) {
+		ok, err := filepath.Match(f.NameGlob, task.Name)
+		if err != nil {
+			return false, fmt.Errorf("invalid name glob %q: %v", f.NameGlob, err)
+		}
+		return ok, nil
+	}
+	return true, nil
+}
+
+func (f *TaskFilter) validate() error {
+	for _, state := range f.States {
+		if !containsFold(taskStates, state) {
+			return fmt.Errorf("invalid state %q, valid states: %s", state, strings.Join(taskStates, ", "))
+		}
+	}
+	for _, t := range f.Types {
+		if !containsFold(taskTypes(), t) {
+			return fmt.Errorf("invalid type %q, valid types: %s", t, strings.Join(taskTypes(), ", "))
+		}
+	}
+	return nil
+}
+
+/*
+taskTypes returns the names of the supported schedule types
+*/
+func taskTypes() []string {
+	return []string{string(db.HMSTask), string(db.DayTimeTask)}
+}
+
+func containsFold(values []string, value string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, value) {
+			return true
+		}
+	}
+	return false
+}
+
+/*
+compareTime orders optional timestamps, tasks without a time sort last
+*/
+func compareTime(a, b *time.Time) bool {
+	if a == nil {
+		return false
+	}
+	if b == nil {
+		return true
+	}
+	return a.Before(*b)
+}
+
+// taskSorters maps --sort-by values onto less functions
+var taskSorters = map[string]func(a, b *db.TaskModel) bool{
+	"name":     func(a, b *db.TaskModel) bool { return a.Name < b.Name },
+	"type":     func(a, b *db.TaskModel) bool { return a.Type < b.Type },
+	"schedule": func(a, b *db.TaskModel) bool { return a.Schedule < b.Schedule },
+	"state":    func(a, b *db.TaskModel) bool { return taskState(a) < taskState(b) },
+	"next":     func(a, b *db.TaskModel) bool { return compareTime(a.NextExecutionTime, b.NextExecutionTime) },
+	"last":     func(a, b *db.TaskModel) bool { return compareTime(a.LastExecutionTime, b.LastExecutionTime) },
+}
+
+/*
+listTasks prints the tasks matching filter, sorted by sortBy
+*/
+func listTasks(filter *TaskFilter, sortBy string, reverse bool, columns []string) error {
+	if err := filter.validate(); err != nil {
+		return err
+	}
+	less, ok := taskSorters[sortBy]
+	if !ok {
+		return fmt.Errorf("invalid sort key %q, valid keys: name, type, schedule, state, next, last", sortBy)
+	}
+	if err := checkTaskColumns(columns); err != nil {
+		return err
+	}
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return err
+	}
+	selected := make([]*db.TaskModel, 0, len(tasks))
+	for _, task := range tasks {
+		ok, err := filter.Match(task)
+		if err != nil {
+			return err
+		}
+		if ok {
+			selected = append(selected, task)
+		}
+	}
+	sort.SliceStable(selected, func(i, j int) bool {
+		if reverse {
+			return less(selected[j], selected[i])
+		}
+		return less(selected[i], selected[j])
+	})
+	return printTasks(selected, columns...)
+}
+
+func newTaskListCMD() *cobra.Command {
+	filter := TaskFilter{}
+	var sortBy string
+	var reverse bool
+	var columns []string
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "list tasks",
+		Long:  "prints a table of all tasks, optionally filtered by state, type, tag or name",
+		Example: "keiji task list --state=error,disabled\n" +
+			"keiji task list --type=HMS --name-glob='ping_*' --sort-by=next\n" +
+			"keiji task list --columns=name,state,error\n" +
+			"keiji task list --tag=billing",
+		Args: cobra.NoArgs,
+		RunE: taskAction(func() error {
+			return listTasks(&filter, sortBy, reverse, columns)
+		}),
+	}
+	cmd.Flags().StringSliceVar(&filter.States, "state", nil, "only list tasks in these states: idle, running, queued, error, disabled")
+	cmd.Flags().StringSliceVar(&filter.Types, "type", nil, "only list tasks of these types: HMS, DayTime")
+	cmd.Flags().StringSliceVar(&filter.Tags, "tag", nil, "only list tasks carrying one of these tags")
+	cmd.Flags().StringVar(&filter.NameGlob, "name-glob", "", "only list tasks whose name matches the glob pattern e.g 'etl_*'")
+	cmd.Flags().StringVar(&sortBy, "sort-by", "name", "sort tasks by name, type, schedule, state, next or last")
+	cmd.Flags().BoolVar(&reverse, "reverse", false, "reverse the sort order")
+	cmd.Flags().StringSliceVar(&columns, "columns", nil, "table columns to show: name, type, schedule, state, last, next, id, description, tags, retry, timeout, overlap, depends, error, logs")
+	return cmd
+}
@@ -0,0 +1,367 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/spf13/cobra"
+)
+
+// stdLogLayout is the timestamp prefix written by the standard library logger
+const stdLogLayout = "2006/01/02 15:04:05"
+
+// logLevels orders slog levels by severity
+var logLevels = map[string]int{
+	"DEBUG": 0,
+	"INFO":  1,
+	"WARN":  2,
+	"ERROR": 3,
+}
+
+/*
+LogRecord is a single parsed log entry of a task or service
+*/
+type LogRecord struct {
+	Time    time.Time         `json:"time" yaml:"time"`
+	Level   string            `json:"level" yaml:"level"`
+	Source  string            `json:"source" yaml:"source"`
+	Message string            `json:"msg" yaml:"msg"`
+	Attrs   map[string]string `json:"attrs,omitempty" yaml:"attrs,omitempty"`
+}
+
+/*
+LogQuery holds the criteria used by `keiji logs query`
+*/
+type LogQuery struct {
+	Tasks    []string
+	Services []string
+	Level    string
+	Since    string
+	Until    string
+	Grep     string
+	Lines    int
+}
+
+/*
+parseLogValues splits slog text output i.e key=value pairs where values
+may be quoted, into a map
+*/
+func parseLogValues(line string) (map[string]string, bool) {
+	values := make(map[string]string)
+	rest := strings.TrimSpace(line)
+	for len(rest) > 0 {
+		eq := strings.IndexByte(rest, '=')
+		if eq <= 0 || strings.ContainsAny(rest[:eq], " \"") {
+			return nil, false
+		}
+		key := rest[:eq]
+		rest = rest[eq+1:]
+		var value string
+		if strings.HasPrefix(rest, "\"") {
+			end := 1
+			for end < len(rest) && rest[end] != '"' {
+				if rest[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(rest) {
+				return nil, false
+			}
+			unquoted, err := strconv.Unquote(rest[:end+1])
+			if err != nil {
+				return nil, false
+			}
+			value = unquoted
+			rest = rest[end+1:]
+		} else {
+			end := strings.IndexByte(rest, ' ')
+			if end < 0 {
+				end = len(rest)
+			}
+			value = rest[:end]
+			rest = rest[end:]
+		}
+		values[key] = value
+		rest = strings.TrimLeft(rest, " ")
+	}
+	return values, true
+}
+
+/*
+parseLogLine parses slog text records as well as lines written by the
+standard library logger. ok is false for lines in neither format
+*/
+func parseLogLine(line string) (record LogRecord, ok bool) {
+	if strings.HasPrefix(line, "time=") {
+		values, ok := parseLogValues(line)
+		if !ok {
+			return record, false
+		}
+		t, err := time.Parse(time.RFC3339Nano, values["time"])
+		if err != nil {
+			return record, false
+		}
+		record.Time = t
+		record.Level = strings.ToUpper(values["level"])
+		record.Message = values["msg"]
+		for _, key := range []string{"time", "level", "msg"} {
+			delete(values, key)
+		}
+		if len(values) > 0 {
+			record.Attrs = values
+		}
+		return record, true
+	}
+	if len(line) < len(stdLogLayout) {
+		return record, false
+	}
+	t, err := time.ParseInLocation(stdLogLayout, line[:len(stdLogLayout)], time.Local)
+	if err != nil {
+		return record, false
+	}
+	record.Time = t
+	record.Level = "INFO"
+	record.Message = strings.TrimSpace(line[len(stdLogLayout):])
+	return record, true
+}
+
+/*
+readLogRecords parses every record in the log file at path. Lines that are
+not records e.g multi-line output are appended to the preceding record
+*/
+func readLogRecords(path string, source string) ([]LogRecord, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	records := make([]LogRecord, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(strings.TrimSpace(line)) == 0 {
+			continue
+		}
+		record, ok := parseLogLine(line)
+		if !ok {
+			if len(records) > 0 {
+				records[len(records)-1].Message += "\n" + line
+			}
+			continue
+		}
+		record.Source = source
+		records = append(records, record)
+	}
+	return records, scanner.Err()
+}
+
+/*
+logFiles returns path along with its rotated copies
+*/
+func logFiles(path string) []string {
+	files := []string{path}
+	rotated, err := filepath.Glob(path + ".*")
+	if err == nil {
+		files = append(files, rotated...)
+	}
+	return files
+}
+
+/*
+parseQueryTime parses --since/--until values which may be RFC3339 timestamps,
+dates or durations relative to now e.g 2h
+*/
+func parseQueryTime(value string) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, use RFC3339, YYYY-MM-DD or a duration such as 2h", value)
+}
+
+/*
+logSources maps the log files selected by the query onto their source name.
+All tasks and services are selected when none is provided
+*/
+func (q *LogQuery) logSources() (map[string]string, error) {
+	sources := make(map[string]string)
+	tasks := q.Tasks
+	services := q.Services
+	if len(tasks) == 0 && len(services) == 0 {
+		all, err := cmdRepo.GetAllTasks()
+		if err != nil {
+			return nil, err
+		}
+		for _, task := range all {
+			tasks = append(tasks, task.Name)
+		}
+		services = serviceNames()
+	}
+	for _, name := range tasks {
+		task, err := getTaskByName(name)
+		if err != nil {
+			return nil, err
+		}
+		sources[task.LogPath] = task.Name
+	}
+	resolved := []c.Service{}
+	if len(services) > 0 {
+		var err error
+		if resolved, err = resolveServices(services); err != nil {
+			return nil, err
+		}
+	}
+	for _, service := range resolved {
+		logsPath, err := getServiceLogPath(service)
+		if err != nil {
+			return nil, err
+		}
+		sources[logsPath] = string(service)
+	}
+	return sources, nil
+}
+
+/*
+Run returns the records matching the query merged into a single time-ordered stream
+*/
+func (q *LogQuery) Run() ([]LogRecord, error) {
+	minLevel := 0
+	if valid(q.Level) {
+		level, ok := logLevels[strings.ToUpper(q.Level)]
+		if !ok {
+			return nil, fmt.Errorf("invalid level %q, must be one of debug, info, warn, error", q.Level)
+		}
+		minLevel = level
+	}
+	var since, until time.Time
+	var err error
+	if valid(q.Since) {
+		if since, err = parseQueryTime(q.Since); err != nil {
+			return nil, err
+		}
+	}
+	if valid(q.Until) {
+		if until, err = parseQueryTime(q.Until); err != nil {
+			return nil, err
+		}
+	}
+	var pattern *regexp.Regexp
+	if valid(q.Grep) {
+		if pattern, err = regexp.Compile(q.Grep); err != nil {
+			return nil, fmt.Errorf("invalid --grep pattern: %v", err)
+		}
+	}
+	sources, err := q.logSources()
+	if err != nil {
+		return nil, err
+	}
+	records := make([]LogRecord, 0)
+	for path, source := range sources {
+		for _, file := range logFiles(path) {
+			fileRecords, err := readLogRecords(file, source)
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			for _, record := range fileRecords {
+				if logLevels[record.Level] < minLevel {
+					continue
+				}
+				if !since.IsZero() && record.Time.Before(since) {
+					continue
+				}
+				if !until.IsZero() && record.Time.After(until) {
+					continue
+				}
+				if pattern != nil && !pattern.MatchString(record.Message) {
+					continue
+				}
+				records = append(records, record)
+			}
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	if q.Lines > 0 && len(records) > q.Lines {
+		records = records[len(records)-q.Lines:]
+	}
+	return records, nil
+}
+
+func printLogRecords(records []LogRecord) error {
+	return printOutput("LogRecordList", records, func(w io.Writer, wide bool) {
+		for _, record := range records {
+			line := fmt.Sprintf("%s %-5s [%s] %s", record.Time.Format(time.RFC3339), record.Level, record.Source, record.Message)
+			if wide && len(record.Attrs) > 0 {
+				keys := make([]string, 0, len(record.Attrs))
+				for key := range record.Attrs {
+					keys = append(keys, key)
+				}
+				sort.Strings(keys)
+				for _, key := range keys {
+					line += fmt.Sprintf(" %s=%q", key, record.Attrs[key])
+				}
+			}
+			fmt.Fprintln(w, line)
+		}
+	})
+}
+
+func NewLogsCMD() *cobra.Command {
+	logsCMD := &cobra.Command{
+		Use:   "logs",
+		Short: "query task and service logs",
+		Long:  "commands to search the logs written by tasks and services",
+	}
+	logsCMD.AddCommand(newLogsQueryCMD())
+	return logsCMD
+}
+
+func newLogsQueryCMD() *cobra.Command {
+	query := LogQuery{}
+	cmd := &cobra.Command{
+		Use:   "query",
+		Short: "search task and service logs",
+		Long: "parses task & service logs and prints the matching records as one time-ordered stream.\n" +
+			"All tasks and services are searched unless --task or --service is provided",
+		Example: "keiji logs query --task=extract --task=load --level=warn --since=2h\n" +
+			"keiji logs query --service=scheduler --grep='interval' --lines=20 -o json",
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWorkSpace(); err != nil {
+				return err
+			}
+			records, err := query.Run()
+			if err != nil {
+				return err
+			}
+			return printLogRecords(records)
+		},
+	}
+	cmd.Flags().StringSliceVar(&query.Tasks, "task", nil, "task whose logs should be searched, can be repeated")
+	cmd.Flags().StringSliceVar(&query.Services, "service", nil, "service whose logs should be searched, can be repeated")
+	cmd.Flags().StringVar(&query.Level, "level", "", "minimum level: debug, info, warn or error")
+	cmd.Flags().StringVar(&query.Since, "since", "", "only records at or after this time (RFC3339, YYYY-MM-DD or a duration e.g 2h)")
+	cmd.Flags().StringVar(&query.Until, "until", "", "only records at or before this time (RFC3339, YYYY-MM-DD or a duration e.g 30m)")
+	cmd.Flags().StringVar(&query.Grep, "grep", "", "only records whose message matches this regular expression")
+	cmd.Flags().IntVar(&query.Lines, "lines", 0, "only print the last N matching records")
+	return cmd
+}
@@ -0,0 +1,436 @@
+package cli
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestParseLogValues(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   map[string]string
+		wantOk bool
+	}{
+		{
+			name:   "unquoted values",
+			line:   "level=INFO msg=started task=ping_google",
+			want:   map[string]string{"level": "INFO", "msg": "started", "task": "ping_google"},
+			wantOk: true,
+		},
+		{
+			name:   "quoted value with spaces",
+			line:   `level=ERROR msg="request failed" status=503`,
+			want:   map[string]string{"level": "ERROR", "msg": "request failed", "status": "503"},
+			wantOk: true,
+		},
+		{
+			name:   "escaped quote and newline",
+			line:   `msg="said \"hi\"\nbye"`,
+			want:   map[string]string{"msg": "said \"hi\"\nbye"},
+			wantOk: true,
+		},
+		{
+			name:   "empty values",
+			line:   `a= b=""`,
+			want:   map[string]string{"a": "", "b": ""},
+			wantOk: true,
+		},
+		{
+			name:   "surrounding and repeated spaces",
+			line:   "  a=1   b=2  ",
+			want:   map[string]string{"a": "1", "b": "2"},
+			wantOk: true,
+		},
+		{
+			name:   "empty line",
+			line:   "",
+			want:   map[string]string{},
+			wantOk: true,
+		},
+		{
+			name: "plain text",
+			line: "hello world",
+		},
+		{
+			name: "missing key",
+			line: "=value",
+		},
+		{
+			name: "unterminated quote",
+			line: `msg="never closed`,
+		},
+		{
+			name: "key with a space",
+			line: "a b=1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogValues(tt.line)
+			if ok != tt.wantOk {
+				t.Fatalf("parseLogValues(%q) ok = %v, want %v", tt.line, ok, tt.wantOk)
+			}
+			if ok && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLogValues(%q) = %v, want %v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseLogLine(t *testing.T) {
+	tests := []struct {
+		name   string
+		line   string
+		want   LogRecord
+		wantOk bool
+	}{
+		{
+			name: "slog record",
+			line: `time=2026-03-06T10:15:00.123Z level=info msg="task started" task=ping_google`,
+			want: LogRecord{
+				Time:    time.Date(2026, time.March, 6, 10, 15, 0, 123000000, time.UTC),
+				Level:   "INFO",
+				Message: "task started",
+				Attrs:   map[string]string{"task": "ping_google"},
+			},
+			wantOk: true,
+		},
+		{
+			name: "slog record with an offset and no attributes",
+			line: `time=2026-03-06T10:15:00+02:00 level=WARN msg=slow`,
+			want: LogRecord{
+				Time:    time.Date(2026, time.March, 6, 8, 15, 0, 0, time.UTC),
+				Level:   "WARN",
+				Message: "slow",
+			},
+			wantOk: true,
+		},
+		{
+			name: "standard library logger",
+			line: "2026/03/06 10:15:00 attempt 1 of 3 failed: timeout",
+			want: LogRecord{
+				Time:    time.Date(2026, time.March, 6, 10, 15, 0, 0, time.Local),
+				Level:   "INFO",
+				Message: "attempt 1 of 3 failed: timeout",
+			},
+			wantOk: true,
+		},
+		{
+			name: "standard library logger without a message",
+			line: "2026/03/06 10:15:00",
+			want: LogRecord{
+				Time:  time.Date(2026, time.March, 6, 10, 15, 0, 0, time.Local),
+				Level: "INFO",
+			},
+			wantOk: true,
+		},
+		{
+			name: "invalid slog time",
+			line: "time=yesterday level=INFO msg=x",
+		},
+		{
+			name: "malformed slog record",
+			line: `time=2026-03-06T10:15:00Z msg="unterminated`,
+		},
+		{
+			name: "continuation line",
+			line: "\tat main.Function(function.go:12)",
+		},
+		{
+			name: "shorter than a timestamp",
+			line: "panic",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseLogLine(tt.line)
+			if ok != tt.wantOk {
+				t.Fatalf("parseLogLine(%q) ok = %v, want %v", tt.line, ok, tt.wantOk)
+			}
+			if !ok {
+				return
+			}
+			if !got.Time.Equal(tt.want.Time) {
+				t.Errorf("parseLogLine(%q) time = %v, want %v", tt.line, got.Time, tt.want.Time)
+			}
+			got.Time, tt.want.Time = time.Time{}, time.Time{}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseLogLine(%q) = %+v, want %+v", tt.line, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLogRecords(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+	}{
+		{
+			name:    "empty file",
+			content: "",
+			want:    []string{},
+		},
+		{
+			name:    "mixed formats",
+			content: "2026/03/06 10:15:00 first\ntime=2026-03-06T10:15:01Z level=ERROR msg=second\n",
+			want:    []string{"INFO first", "ERROR second"},
+		},
+		{
+			name:    "multi-line output is appended to the preceding record",
+			content: "2026/03/06 10:15:00 panic: boom\ngoroutine 1 [running]:\nmain.main()\n",
+			want:    []string{"INFO panic: boom\ngoroutine 1 [running]:\nmain.main()"},
+		},
+		{
+			name:    "blank lines are skipped",
+			content: "2026/03/06 10:15:00 first\n\n   \n2026/03/06 10:15:01 second\n",
+			want:    []string{"INFO first", "INFO second"},
+		},
+		{
+			name:    "lines before the first record are dropped",
+			content: "orphan\n2026/03/06 10:15:00 first\n",
+			want:    []string{"INFO first"},
+		},
+		{
+			name:    "last line without a newline",
+			content: "2026/03/06 10:15:00 first\n2026/03/06 10:15:01 truncated mid-wri",
+			want:    []string{"INFO first", "INFO truncated mid-wri"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "task.log")
+			if err := os.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			records, err := readLogRecords(path, "ping_google")
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(records))
+			for _, record := range records {
+				if record.Source != "ping_google" {
+					t.Errorf("record source = %q, want ping_google", record.Source)
+				}
+				got = append(got, record.Level+" "+record.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readLogRecords() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadLogRecordsMissingFile(t *testing.T) {
+	if _, err := readLogRecords(filepath.Join(t.TempDir(), "missing.log"), "ping_google"); !os.IsNotExist(err) {
+		t.Errorf("readLogRecords() error = %v, want a not exist error", err)
+	}
+}
+
+func TestLogFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{
+			name:  "no rotated copies",
+			files: []string{"task.log"},
+			want:  []string{"task.log"},
+		},
+		{
+			name:  "rotated copies",
+			files: []string{"task.log", "task.log.20260305", "task.log.20260306"},
+			want:  []string{"task.log", "task.log.20260305", "task.log.20260306"},
+		},
+		{
+			name:  "other logs are ignored",
+			files: []string{"task.log", "task.logs", "other.log.20260306"},
+			want:  []string{"task.log"},
+		},
+		{
+			name:  "only rotated copies",
+			files: []string{"task.log.20260306"},
+			want:  []string{"task.log", "task.log.20260306"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for _, file := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, file), nil, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got := make([]string, 0)
+			for _, file := range logFiles(filepath.Join(dir, "task.log")) {
+				got = append(got, filepath.Base(file))
+			}
+			sort.Strings(got[1:])
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("logFiles() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteRotatedTail(t *testing.T) {
+	old := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name    string
+		rotated map[string]string
+		//stale copies are given an older modification time
+		stale  []string
+		offset int64
+		want   string
+	}{
+		{
+			name:   "no rotated copy",
+			offset: 4,
+			want:   "",
+		},
+		{
+			name:    "lines written after the last read",
+			rotated: map[string]string{"task.log.1": "read\nmissed\n"},
+			offset:  5,
+			want:    "missed\n",
+		},
+		{
+			name:    "nothing missed",
+			rotated: map[string]string{"task.log.1": "read\n"},
+			offset:  5,
+			want:    "",
+		},
+		{
+			name:    "latest copy is used",
+			rotated: map[string]string{"task.log.1": "read\nstale\n", "task.log.2": "read\nlatest\n"},
+			stale:   []string{"task.log.1"},
+			offset:  5,
+			want:    "latest\n",
+		},
+		{
+			name:    "copies shorter than the offset are ignored",
+			rotated: map[string]string{"task.log.1": "read\nkept\n", "task.log.2": "r"},
+			stale:   []string{"task.log.1"},
+			offset:  5,
+			want:    "kept\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "task.log")
+			//the live file was truncated by the rotation
+			if err := os.WriteFile(path, nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.rotated {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, name := range tt.stale {
+				if err := os.Chtimes(filepath.Join(dir, name), old, old); err != nil {
+					t.Fatal(err)
+				}
+			}
+			var out bytes.Buffer
+			if err := writeRotatedTail(path, tt.offset, &out); err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != tt.want {
+				t.Errorf("writeRotatedTail() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTailFileTruncated(t *testing.T) {
+	if testing.Short() {
+		t.Skip("polls the file")
+	}
+	dir := t.TempDir()
+	path := filepath.Join(dir, "task.log")
+	if err := os.WriteFile(path, []byte("before follow\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	var out syncBuffer
+	done := make(chan error, 1)
+	go func() {
+		done <- tailFile(ctx, path, &out)
+	}()
+	appendLog := func(content string) {
+		f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(content); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(want string) {
+		deadline := time.Now().Add(5 * followInterval)
+		for !strings.HasSuffix(out.String(), want) {
+			if time.Now().After(deadline) {
+				t.Fatalf("tailFile() wrote %q, want it to end with %q", out.String(), want)
+			}
+			time.Sleep(followInterval / 10)
+		}
+	}
+	time.Sleep(followInterval / 5)
+	appendLog("first\n")
+	waitFor("first\n")
+	//rotate as keiji-core does, copy then truncate, with a line the follower did not read yet
+	appendLog("missed\n")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path+".20260306", content, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatal(err)
+	}
+	appendLog("after\n")
+	waitFor("after\n")
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "first\nmissed\nafter\n"; got != want {
+		t.Errorf("tailFile() wrote %q, want %q", got, want)
+	}
+}
+
+/*
+syncBuffer is a bytes.Buffer safe for concurrent use
+*/
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
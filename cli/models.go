@@ -0,0 +1,36 @@
+package cli
+
+import (
+	"github.com/aodr3w/keiji/runner"
+)
+
+/*
+TaskRunModel records a single execution of a task, runs are saved by the
+runner inside the task executable
+*/
+type TaskRunModel = runner.TaskRun
+
+/*
+TaskSettingsModel holds the settings a task declares through directives in its schedule.go,
+they are applied by the runner inside the task executable
+*/
+type TaskSettingsModel = runner.TaskSettings
+
+// cliModels are the tables owned by the cli, the tasks table is managed by keiji-core.
+// Every model holds a TaskName column
+var cliModels = []interface{}{
+	&TaskRunModel{},
+	&TaskSettingsModel{},
+}
+
+/*
+deleteTaskData removes the rows of the cli tables that belong to the task named name
+*/
+func deleteTaskData(name string) error {
+	for _, model := range cliModels {
+		if err := cmdRepo.DB.Unscoped().Where("task_name = ?", name).Delete(model).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,503 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji/runner"
+	"gopkg.in/yaml.v3"
+)
+
+type OutputFormat string
+
+const (
+	TABLE OutputFormat = "table"
+	WIDE  OutputFormat = "wide"
+	JSON  OutputFormat = "json"
+	YAML  OutputFormat = "yaml"
+	//outputAPIVersion versions the json/yaml schema, bump it on breaking changes
+	outputAPIVersion = "keiji/v1"
+)
+
+var outputFormats = []OutputFormat{TABLE, WIDE, JSON, YAML}
+
+// output is the value of the global --output flag
+var output = string(TABLE)
+
+/*
+checkOutputFormat confirms that the --output flag holds a supported format
+*/
+func checkOutputFormat() error {
+	for _, format := range outputFormats {
+		if OutputFormat(output) == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid output format %q, must be one of table, wide, json, yaml", output)
+}
+
+/*
+ListView is the envelope used for every json/yaml result so that
+scripts can rely on a stable, versioned schema
+*/
+type ListView struct {
+	APIVersion string      `json:"apiVersion" yaml:"apiVersion"`
+	Kind       string      `json:"kind" yaml:"kind"`
+	Items      interface{} `json:"items" yaml:"items"`
+	//Summary aggregates data related to the items e.g the tasks of `keiji system status`
+	Summary interface{} `json:"summary,omitempty" yaml:"summary,omitempty"`
+}
+
+/*
+TaskView is the serialized representation of a task
+*/
+type TaskView struct {
+	TaskId            string     `json:"taskId" yaml:"taskId"`
+	Name              string     `json:"name" yaml:"name"`
+	Description       string     `json:"description" yaml:"description"`
+	Schedule          string     `json:"schedule" yaml:"schedule"`
+	Type              string     `json:"type" yaml:"type"`
+	LastExecutionTime *time.Time `json:"lastExecutionTime" yaml:"lastExecutionTime"`
+	NextExecutionTime *time.Time `json:"nextExecutionTime" yaml:"nextExecutionTime"`
+	State             string     `json:"state" yaml:"state"`
+	IsRunning         bool       `json:"isRunning" yaml:"isRunning"`
+	IsQueued          bool       `json:"isQueued" yaml:"isQueued"`
+	IsError           bool       `json:"isError" yaml:"isError"`
+	IsDisabled        bool       `json:"isDisabled" yaml:"isDisabled"`
+	ErrorTxt          string     `json:"errorTxt" yaml:"errorTxt"`
+	LogPath           string     `json:"logPath" yaml:"logPath"`
+	Executable        string     `json:"executable" yaml:"executable"`
+	Retry             RetryView  `json:"retry" yaml:"retry"`
+	Timeout           string     `json:"timeout,omitempty" yaml:"timeout,omitempty"`
+	DependsOn         []string   `json:"dependsOn,omitempty" yaml:"dependsOn,omitempty"`
+	Overlap           string     `json:"overlap" yaml:"overlap"`
+	Tags              []string   `json:"tags,omitempty" yaml:"tags,omitempty"`
+	Cron              string     `json:"cron,omitempty" yaml:"cron,omitempty"`
+}
+
+/*
+RetryView is the serialized representation of a task's retry policy
+*/
+type RetryView struct {
+	Attempts int     `json:"attempts" yaml:"attempts"`
+	Backoff  string  `json:"backoff" yaml:"backoff"`
+	Delay    string  `json:"delay" yaml:"delay"`
+	Jitter   float64 `json:"jitter,omitempty" yaml:"jitter,omitempty"`
+	RetryOn  string  `json:"retryOn,omitempty" yaml:"retryOn,omitempty"`
+	Fatal    string  `json:"fatal,omitempty" yaml:"fatal,omitempty"`
+	summary  string
+}
+
+/*
+ServiceView is the serialized representation of a service's status
+*/
+type ServiceView struct {
+	Name      string `json:"name" yaml:"name"`
+	Installed bool   `json:"installed" yaml:"installed"`
+	Running   bool   `json:"running" yaml:"running"`
+	Status    string `json:"status" yaml:"status"`
+	PID       int    `json:"pid,omitempty" yaml:"pid,omitempty"`
+	Uptime    string `json:"uptime,omitempty" yaml:"uptime,omitempty"`
+	LogPath   string `json:"logPath" yaml:"logPath"`
+	//Unit is the systemd unit managing the service, empty when it is managed through its pid file
+	Unit string `json:"unit,omitempty" yaml:"unit,omitempty"`
+	//Supervised is set while `keiji system supervise` runs the service
+	Supervised bool `json:"supervised,omitempty" yaml:"supervised,omitempty"`
+	//Crashes & LastExit are recorded by the last supervisor
+	Crashes  int    `json:"crashes,omitempty" yaml:"crashes,omitempty"`
+	LastExit string `json:"lastExit,omitempty" yaml:"lastExit,omitempty"`
+	//Stale explains why the pid file of the service does not point at it
+	Stale string `json:"stale,omitempty" yaml:"stale,omitempty"`
+	//Version is the module version the service was built from
+	Version string `json:"version,omitempty" yaml:"version,omitempty"`
+	//Health is unhealthy when a port of a running service does not accept connections
+	Health string `json:"health,omitempty" yaml:"health,omitempty"`
+	//MemoryBytes & CPUPercent are read from /proc, CPUPercent averages the usage since the service started
+	MemoryBytes uint64     `json:"memoryBytes,omitempty" yaml:"memoryBytes,omitempty"`
+	CPUPercent  float64    `json:"cpuPercent,omitempty" yaml:"cpuPercent,omitempty"`
+	Ports       []PortView `json:"ports,omitempty" yaml:"ports,omitempty"`
+	LastLog     string     `json:"lastLog,omitempty" yaml:"lastLog,omitempty"`
+}
+
+/*
+taskState summarizes the status flags of a task into a single value
+*/
+func taskState(task *db.TaskModel) string {
+	switch {
+	case task.IsDisabled:
+		return "disabled"
+	case task.IsError:
+		return "error"
+	case task.IsRunning:
+		return "running"
+	case task.IsQueued:
+		return "queued"
+	default:
+		return "idle"
+	}
+}
+
+func newRetryView(policy *runner.Retry) RetryView {
+	return RetryView{
+		Attempts: policy.Attempts,
+		Backoff:  policy.Backoff,
+		Delay:    policy.Delay.String(),
+		Jitter:   policy.Jitter,
+		RetryOn:  policy.RetryOn,
+		Fatal:    policy.Fatal,
+		summary:  policy.String(),
+	}
+}
+
+func newTaskView(task *db.TaskModel, settings *TaskSettingsModel, tags []string) TaskView {
+	view := TaskView{
+		TaskId:            task.TaskId,
+		Name:              task.Name,
+		Description:       task.Description,
+		Schedule:          task.Schedule,
+		Type:              string(task.Type),
+		LastExecutionTime: task.LastExecutionTime,
+		NextExecutionTime: task.NextExecutionTime,
+		State:             taskState(task),
+		IsRunning:         task.IsRunning,
+		IsQueued:          task.IsQueued,
+		IsError:           task.IsError,
+		IsDisabled:        task.IsDisabled,
+		ErrorTxt:          task.ErrorTxt,
+		LogPath:           task.LogPath,
+		Executable:        task.Executable,
+		Retry:             newRetryView(&settings.Retry),
+		DependsOn:         settings.DependsOn,
+		Overlap:           settings.Overlap,
+		Tags:              tags,
+		Cron:              settings.Cron,
+	}
+	if settings.Timeout > 0 {
+		view.Timeout = settings.Timeout.String()
+	}
+	return view
+}
+
+/*
+newServiceView collects the installation, runtime status and health of a service
+*/
+func newServiceView(service c.Service) (ServiceView, error) {
+	view, err := serviceState(service)
+	if err != nil || !view.Installed {
+		return view, err
+	}
+	view.Version = serviceVersion(service)
+	if line, err := lastLogLine(view.LogPath); err == nil {
+		view.LastLog = line
+	}
+	if !view.Running {
+		return view, nil
+	}
+	if view.PID > 0 && hasProc() {
+		if started, rss, cpu, err := procUsage(view.PID); err == nil {
+			uptime := time.Since(started)
+			view.Uptime, view.MemoryBytes = uptime.Truncate(time.Second).String(), rss
+			if uptime > 0 {
+				view.CPUPercent = math.Round(1000*cpu.Seconds()/uptime.Seconds()) / 10
+			}
+		}
+	}
+	view.Health = Healthy
+	view.Ports = checkPorts(service)
+	for _, port := range view.Ports {
+		if !port.Reachable {
+			view.Health = Unhealthy
+		}
+	}
+	return view, nil
+}
+
+/*
+serviceState collects the installation and runtime status of a service
+*/
+func serviceState(service c.Service) (ServiceView, error) {
+	view := ServiceView{
+		Name:    string(service),
+		Status:  "NOT INSTALLED",
+		LogPath: serviceLogsMapping[service],
+	}
+	installed, err := isServiceInstalled(service)
+	if err != nil {
+		return view, err
+	}
+	if !installed {
+		return view, nil
+	}
+	view.Installed = true
+	view.Status = string(c.OFFLINE)
+	if state, err := readSupervisorState(); err != nil {
+		logError(err)
+	} else if state != nil && state.Services[service] != nil {
+		view.Supervised = state.PID > 0 && supervisorPID() == state.PID
+		view.Crashes, view.LastExit = state.Services[service].Crashes, state.Services[service].LastExit
+	}
+	if scope := installedUnits(); valid(scope) {
+		view.Unit = unitName(service)
+		active, pid, since, err := unitStatus(scope, service)
+		if err != nil || !active {
+			return view, err
+		}
+		view.Running, view.Status, view.PID = true, string(c.ONLINE), pid
+		if !since.IsZero() {
+			view.Uptime = time.Since(since).Truncate(time.Second).String()
+		}
+		return view, nil
+	}
+	process, err := readServiceProcess(service)
+	if err != nil || process == nil {
+		return view, err
+	}
+	view.PID = process.PID
+	if valid(process.Stale) {
+		view.Status, view.Stale = string(STALE), process.Stale
+		return view, nil
+	}
+	view.Running = true
+	view.Status = string(c.ONLINE)
+	//the pid file is written when the service is started
+	if info, err := os.Stat(paths.PID_PATH(service)); err == nil {
+		view.Uptime = time.Since(info.ModTime()).Truncate(time.Second).String()
+	}
+	return view, nil
+}
+
+/*
+formatTime renders optional timestamps for tables
+*/
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "N/A"
+	}
+	return t.Format(time.RFC3339)
+}
+
+/*
+printOutput writes items to stdout in the format selected by --output.
+printTable is used for the table & wide formats
+*/
+func printOutput(kind string, items interface{}, printTable func(w io.Writer, wide bool)) error {
+	return printView(ListView{
+		APIVersion: outputAPIVersion,
+		Kind:       kind,
+		Items:      items,
+	}, printTable)
+}
+
+/*
+printView writes view to stdout in the format selected by --output
+*/
+func printView(view ListView, printTable func(w io.Writer, wide bool)) error {
+	switch OutputFormat(output) {
+	case JSON:
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(view)
+	case YAML:
+		encoder := yaml.NewEncoder(os.Stdout)
+		encoder.SetIndent(2)
+		defer encoder.Close()
+		return encoder.Encode(view)
+	default:
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 3, ' ', 0)
+		printTable(w, OutputFormat(output) == WIDE)
+		return w.Flush()
+	}
+}
+
+/*
+taskColumn describes a column that can be shown when tasks are printed as a table
+*/
+type taskColumn struct {
+	header string
+	value  func(v TaskView) string
+}
+
+var taskColumns = map[string]taskColumn{
+	"name": {"NAME", func(v TaskView) string { return v.Name }},
+	"type": {"TYPE", func(v TaskView) string { return v.Type }},
+	"schedule": {"SCHEDULE", func(v TaskView) string {
+		//cron tasks run at the fire times of their expression rather than every poll
+		if len(v.Cron) > 0 {
+			return "cron:" + v.Cron
+		}
+		return v.Schedule
+	}},
+	"state":       {"STATE", func(v TaskView) string { return v.State }},
+	"last":        {"LAST RUN", func(v TaskView) string { return formatTime(v.LastExecutionTime) }},
+	"next":        {"NEXT RUN", func(v TaskView) string { return formatTime(v.NextExecutionTime) }},
+	"id":          {"TASK ID", func(v TaskView) string { return v.TaskId }},
+	"description": {"DESCRIPTION", func(v TaskView) string { return v.Description }},
+	"error":       {"ERROR", func(v TaskView) string { return v.ErrorTxt }},
+	"logs":        {"LOGS", func(v TaskView) string { return v.LogPath }},
+	"retry":       {"RETRY", func(v TaskView) string { return v.Retry.summary }},
+	"overlap":     {"OVERLAP", func(v TaskView) string { return v.Overlap }},
+	"depends": {"DEPENDS ON", func(v TaskView) string {
+		if len(v.DependsOn) == 0 {
+			return "-"
+		}
+		return strings.Join(v.DependsOn, ",")
+	}},
+	"tags": {"TAGS", func(v TaskView) string {
+		if len(v.Tags) == 0 {
+			return "-"
+		}
+		return strings.Join(v.Tags, ",")
+	}},
+	"timeout": {"TIMEOUT", func(v TaskView) string {
+		if len(v.Timeout) == 0 {
+			return "none"
+		}
+		return v.Timeout
+	}},
+}
+
+var (
+	defaultTaskColumns = []string{"name", "type", "schedule", "state", "last", "next"}
+	wideTaskColumns    = []string{"name", "type", "schedule", "state", "last", "next", "id", "description", "tags", "retry", "timeout", "error"}
+)
+
+/*
+checkTaskColumns confirms that every requested column is known
+*/
+func checkTaskColumns(columns []string) error {
+	for _, column := range columns {
+		if _, ok := taskColumns[column]; !ok {
+			known := make([]string, 0, len(taskColumns))
+			for name := range taskColumns {
+				known = append(known, name)
+			}
+			sort.Strings(known)
+			return fmt.Errorf("invalid column %q, valid columns: %s", column, strings.Join(known, ", "))
+		}
+	}
+	return nil
+}
+
+/*
+printTasks prints tasks in the format selected by --output. columns selects the
+table columns, the default or wide columns are used when it is empty
+*/
+func printTasks(tasks []*db.TaskModel, columns ...string) error {
+	settings, err := getAllTaskSettings()
+	if err != nil {
+		return err
+	}
+	views := make([]TaskView, 0, len(tasks))
+	for _, task := range tasks {
+		taskSettings, ok := settings[task.Name]
+		if !ok {
+			taskSettings = runner.NewTaskSettings(task.Name)
+		}
+		//tags are only kept in the task's .env file
+		tags, err := taskTags(task.Name)
+		if err != nil {
+			logWarn(fmt.Sprintf("task %v: %v", task.Name, err))
+		}
+		views = append(views, newTaskView(task, taskSettings, tags))
+	}
+	return printOutput("TaskList", views, func(w io.Writer, wide bool) {
+		if len(columns) == 0 {
+			columns = defaultTaskColumns
+			if wide {
+				columns = wideTaskColumns
+			}
+		}
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = taskColumns[column].header
+		}
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+		for _, v := range views {
+			for i, column := range columns {
+				row[i] = taskColumns[column].value(v)
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+	})
+}
+
+func printServices(services []ServiceView, summary *TaskSummary) error {
+	view := ListView{
+		APIVersion: outputAPIVersion,
+		Kind:       "ServiceList",
+		Items:      services,
+	}
+	if summary != nil {
+		view.Summary = summary
+	}
+	return printView(view, func(w io.Writer, wide bool) {
+		header := []string{"NAME", "STATUS", "HEALTH", "VERSION", "PID", "UPTIME", "MEM", "CPU"}
+		if wide {
+			header = append(header, "PORTS", "LOGS", "UNIT", "CRASHES", "LAST EXIT", "LAST LOG")
+		}
+		fmt.Fprintln(w, strings.Join(header, "\t"))
+		for _, v := range services {
+			row := []string{v.Name, v.Status, orDash(v.Health), orDash(v.Version), "-", orDash(v.Uptime), "-", "-"}
+			if v.PID > 0 {
+				row[4] = fmt.Sprint(v.PID)
+			}
+			if v.MemoryBytes > 0 {
+				row[6] = formatBytes(v.MemoryBytes)
+			}
+			if v.Running && v.MemoryBytes > 0 {
+				row[7] = fmt.Sprintf("%.1f%%", v.CPUPercent)
+			}
+			if wide {
+				ports := make([]string, 0, len(v.Ports))
+				for _, port := range v.Ports {
+					state := "down"
+					if port.Reachable {
+						state = "up"
+					}
+					ports = append(ports, port.Port+" "+state)
+				}
+				unit := v.Unit
+				if !valid(unit) && v.Supervised {
+					unit = "supervisor"
+				}
+				row = append(row, orDash(strings.Join(ports, ", ")), v.LogPath, orDash(unit), fmt.Sprint(v.Crashes), orDash(v.LastExit), orDash(truncate(v.LastLog, maxLastLogWidth)))
+			}
+			fmt.Fprintln(w, strings.Join(row, "\t"))
+		}
+		if summary != nil {
+			printTaskSummary(w, summary, wide)
+		}
+	})
+}
+
+/*
+truncate shortens value to width characters ending with ..., it counts runes
+so that multi-byte characters are never cut
+*/
+func truncate(value string, width int) string {
+	runes := []rune(value)
+	if len(runes) <= width {
+		return value
+	}
+	return string(runes[:width-3]) + "..."
+}
+
+/*
+orDash renders empty table cells as -
+*/
+func orDash(value string) string {
+	if len(value) == 0 {
+		return "-"
+	}
+	return value
+}
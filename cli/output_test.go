@@ -0,0 +1,34 @@
+package cli
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		width int
+		want  string
+	}{
+		{name: "shorter", value: "scheduler started", width: 20, want: "scheduler started"},
+		{name: "exact width", value: "0123456789", width: 10, want: "0123456789"},
+		{name: "longer", value: "scheduler started with 3 tasks", width: 12, want: "scheduler..."},
+		{name: "multi-byte characters", value: "tâche démarrée à 10h", width: 10, want: "tâche d..."},
+		{name: "multi-byte characters within width", value: "démarrée ✓", width: 10, want: "démarrée ✓"},
+		{name: "emoji at the cut", value: "done 🎉🎉🎉🎉🎉", width: 8, want: "done ..."},
+		{name: "empty", value: "", width: 10, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := truncate(tt.value, tt.width)
+			if got != tt.want {
+				t.Errorf("truncate(%q, %d) = %q, want %q", tt.value, tt.width, got, tt.want)
+			}
+			if !utf8.ValidString(got) {
+				t.Errorf("truncate(%q, %d) = %q is not valid UTF-8", tt.value, tt.width, got)
+			}
+		})
+	}
+}
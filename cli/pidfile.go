@@ -0,0 +1,219 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+)
+
+// STALE is reported for services whose pid file points at a process that exited or is not the service
+const STALE c.ServiceStatus = "STALE"
+
+// procRoot exposes the identity of processes, it is missing on macOS where pids are only checked with kill
+var procRoot = "/proc"
+
+/*
+ServiceProcess is the process recorded in the pid file of a service
+*/
+type ServiceProcess struct {
+	PID int
+	//Start is the start time of the process in clock ticks since boot, 0 when the pid file does not record it
+	Start uint64
+	//Stale explains why the pid file does not point at the service, empty when it does
+	Stale string
+}
+
+/*
+procStatus holds the fields of /proc/<pid>/stat used to identify a process
+*/
+type procStatus struct {
+	//State is e.g R for running or Z for zombies
+	State string
+	PPID  int
+	//UTime & STime are the cpu time spent in user & kernel mode in clock ticks
+	UTime uint64
+	STime uint64
+	//Start is the start time of the process in clock ticks since boot
+	Start uint64
+}
+
+func procStat(pid int) (*procStatus, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return nil, err
+	}
+	//the command name may contain spaces & parentheses, fields are counted after its closing parenthesis
+	i := strings.LastIndexByte(string(stat), ')')
+	if i < 0 {
+		return nil, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	fields := strings.Fields(string(stat[i+1:]))
+	//starttime is the 22nd field, the 20th after the command name
+	if len(fields) < 20 {
+		return nil, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	status := &procStatus{State: fields[0]}
+	if status.PPID, err = strconv.Atoi(fields[1]); err != nil {
+		return nil, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	//utime & stime are the 14th & 15th fields
+	for field, value := range map[int]*uint64{11: &status.UTime, 12: &status.STime, 19: &status.Start} {
+		if *value, err = strconv.ParseUint(fields[field], 10, 64); err != nil {
+			return nil, fmt.Errorf("invalid stat of process %d", pid)
+		}
+	}
+	return status, nil
+}
+
+/*
+procArgs returns the command line of process pid
+*/
+func procArgs(pid int) ([]string, error) {
+	cmdline, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "cmdline"))
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00"), nil
+}
+
+/*
+hasProc reports whether process identities can be read from /proc
+*/
+func hasProc() bool {
+	ok, _ := utils.PathExists(filepath.Join(procRoot, "self", "stat"))
+	return ok
+}
+
+/*
+procRuns reports whether process pid runs the executable of service, services started
+through a shell script run the script as their first argument
+*/
+func procRuns(pid int, service c.Service) (bool, error) {
+	args, err := procArgs(pid)
+	if err != nil {
+		return false, err
+	}
+	executable := fmt.Sprintf("keiji-%v", service)
+	for i := 0; i < len(args) && i < 2; i++ {
+		if filepath.Base(args[i]) == executable {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+/*
+readServiceProcess reads the pid file of service and verifies that the process it records is the service.
+It returns nil when the service has no pid file
+*/
+func readServiceProcess(service c.Service) (*ServiceProcess, error) {
+	pidPath := paths.PID_PATH(service)
+	content, err := os.ReadFile(pidPath)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	//the pid file holds the pid, then the start time of the process when keiji wrote it
+	lines := strings.Fields(string(content))
+	if len(lines) == 0 {
+		return &ServiceProcess{Stale: "empty pid file"}, nil
+	}
+	process := &ServiceProcess{}
+	if process.PID, err = strconv.Atoi(lines[0]); err != nil || process.PID <= 0 {
+		return &ServiceProcess{Stale: fmt.Sprintf("invalid pid %q", lines[0])}, nil
+	}
+	if len(lines) > 1 {
+		process.Start, _ = strconv.ParseUint(lines[1], 10, 64)
+	}
+	if err := syscall.Kill(process.PID, 0); err == syscall.ESRCH {
+		process.Stale = fmt.Sprintf("pid %d exited", process.PID)
+		return process, nil
+	} else if err != nil && err != syscall.EPERM {
+		return nil, fmt.Errorf("%d: %v", process.PID, err)
+	}
+	if !hasProc() {
+		return process, nil
+	}
+	status, err := procStat(process.PID)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	//a process that exited since it was signalled may still be waiting for its parent to reap it
+	if os.IsNotExist(err) || status.State == "Z" {
+		process.Stale = fmt.Sprintf("pid %d exited", process.PID)
+		return process, nil
+	}
+	if process.Start > 0 {
+		if status.Start != process.Start {
+			process.Stale = fmt.Sprintf("pid %d was reused by another process", process.PID)
+			return process, nil
+		}
+	}
+	runs, err := procRuns(process.PID, service)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if !runs {
+		process.Stale = fmt.Sprintf("pid %d is not keiji-%v", process.PID, service)
+	}
+	return process, nil
+}
+
+/*
+writePIDFile records pid in pidPath along with its start time when /proc is available
+*/
+func writePIDFile(pidPath string, pid int) error {
+	content := fmt.Sprintf("%d\n", pid)
+	if status, err := procStat(pid); err == nil {
+		content += fmt.Sprintf("%d\n", status.Start)
+	}
+	return os.WriteFile(pidPath, []byte(content), 0644)
+}
+
+/*
+lockPIDFile serializes starting & stopping service across keiji processes,
+the returned function releases the lock
+*/
+func lockPIDFile(service c.Service) (func(), error) {
+	pidPath := paths.PID_PATH(service)
+	if err := os.MkdirAll(filepath.Dir(pidPath), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(pidPath+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+/*
+removeStalePIDFile removes the pid file of service when it does not point at the service,
+it returns the process recorded in the pid file
+*/
+func removeStalePIDFile(service c.Service) (*ServiceProcess, error) {
+	process, err := readServiceProcess(service)
+	if err != nil || process == nil || !valid(process.Stale) {
+		return process, err
+	}
+	if err := os.Remove(paths.PID_PATH(service)); err != nil && !os.IsNotExist(err) {
+		return process, err
+	}
+	logWarn(fmt.Sprintf("removed stale pid file of %v: %v", service, process.Stale))
+	return process, nil
+}
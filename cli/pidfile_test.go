@@ -0,0 +1,221 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/paths"
+)
+
+/*
+writeProc writes the stat and cmdline of a fake process pid under procRoot
+*/
+func writeProc(t *testing.T, pid int, stat string, args ...string) {
+	dir := filepath.Join(procRoot, strconv.Itoa(pid))
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "stat"), []byte(stat), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmdline := ""
+	for _, arg := range args {
+		cmdline += arg + "\x00"
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cmdline"), []byte(cmdline), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+/*
+statLine returns a /proc/<pid>/stat line of a process named comm
+*/
+func statLine(pid int, comm string, state string, ppid int, start uint64) string {
+	return fmt.Sprintf("%d (%s) %s %d %d %d 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 1 0 %d 1000 100", pid, comm, state, ppid, pid, pid, start)
+}
+
+func TestProcStat(t *testing.T) {
+	defer func(root string) { procRoot = root }(procRoot)
+	tests := []struct {
+		name    string
+		stat    string
+		want    *procStatus
+		wantErr bool
+	}{
+		{
+			name: "running",
+			stat: statLine(4242, "keiji-scheduler", "S", 1, 9100),
+			want: &procStatus{State: "S", PPID: 1, UTime: 5, STime: 3, Start: 9100},
+		},
+		{
+			name: "command name with a parenthesis",
+			stat: statLine(4242, "evil) R 7 (x", "S", 1, 9100),
+			want: &procStatus{State: "S", PPID: 1, UTime: 5, STime: 3, Start: 9100},
+		},
+		{
+			name: "command name with spaces",
+			stat: statLine(4242, "keiji task", "R", 300, 9100),
+			want: &procStatus{State: "R", PPID: 300, UTime: 5, STime: 3, Start: 9100},
+		},
+		{
+			name: "zombie",
+			stat: statLine(4242, "keiji-scheduler", "Z", 1, 9100),
+			want: &procStatus{State: "Z", PPID: 1, UTime: 5, STime: 3, Start: 9100},
+		},
+		{
+			name:    "truncated",
+			stat:    "4242 (keiji-scheduler) S 1 4242",
+			wantErr: true,
+		},
+		{
+			name:    "no command name",
+			stat:    "4242 keiji-scheduler S 1",
+			wantErr: true,
+		},
+		{
+			name:    "invalid start time",
+			stat:    "4242 (keiji-scheduler) S 1 4242 4242 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 1 0 soon 1000 100",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot = t.TempDir()
+			writeProc(t, 4242, tt.stat, "keiji-scheduler")
+			got, err := procStat(4242)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("procStat() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("procStat() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReadServiceProcess(t *testing.T) {
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	defer func(root, executables string) {
+		procRoot, paths.SERVICE_EXECUTABLE = root, executables
+	}(procRoot, paths.SERVICE_EXECUTABLE)
+	//the test process stands for the service, its identity is read from the fake /proc
+	pid := os.Getpid()
+	tests := []struct {
+		name      string
+		noPIDFile bool
+		pidFile   string
+		stat      string
+		args      []string
+		want      *ServiceProcess
+	}{
+		{
+			name:    "running service",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "keiji-scheduler", "S", 1, 9100),
+			args:    []string{"/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid, Start: 9100},
+		},
+		{
+			name:    "started through a shell script",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "sh", "S", 1, 9100),
+			args:    []string{"/bin/sh", "/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid, Start: 9100},
+		},
+		{
+			name:    "command name with a parenthesis",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "keiji) S 1 (scheduler", "S", 1, 9100),
+			args:    []string{"/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid, Start: 9100},
+		},
+		{
+			name:    "pid file without a start time",
+			pidFile: fmt.Sprintf("%d\n", pid),
+			stat:    statLine(pid, "keiji-scheduler", "S", 1, 9100),
+			args:    []string{"/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid},
+		},
+		{
+			name:    "zombie",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "keiji-scheduler", "Z", 1, 9100),
+			args:    []string{"/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid, Start: 9100, Stale: fmt.Sprintf("pid %d exited", pid)},
+		},
+		{
+			name:    "start time mismatch",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "keiji-scheduler", "S", 1, 12000),
+			args:    []string{"/home/keiji/go/bin/keiji-scheduler"},
+			want:    &ServiceProcess{PID: pid, Start: 9100, Stale: fmt.Sprintf("pid %d was reused by another process", pid)},
+		},
+		{
+			name:    "non-keiji cmdline",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "postgres", "S", 1, 9100),
+			args:    []string{"/usr/lib/postgresql/16/bin/postgres", "-D", "/var/lib/postgresql"},
+			want:    &ServiceProcess{PID: pid, Start: 9100, Stale: fmt.Sprintf("pid %d is not keiji-scheduler", pid)},
+		},
+		{
+			name:    "other keiji service",
+			pidFile: fmt.Sprintf("%d\n9100\n", pid),
+			stat:    statLine(pid, "keiji-bus", "S", 1, 9100),
+			args:    []string{"/home/keiji/go/bin/keiji-bus"},
+			want:    &ServiceProcess{PID: pid, Start: 9100, Stale: fmt.Sprintf("pid %d is not keiji-scheduler", pid)},
+		},
+		{
+			name:    "exited",
+			pidFile: fmt.Sprintf("%d\n9100\n", exited.Process.Pid),
+			want:    &ServiceProcess{PID: exited.Process.Pid, Start: 9100, Stale: fmt.Sprintf("pid %d exited", exited.Process.Pid)},
+		},
+		{
+			name:    "empty pid file",
+			pidFile: "",
+			want:    &ServiceProcess{Stale: "empty pid file"},
+		},
+		{
+			name:    "invalid pid",
+			pidFile: "scheduler\n",
+			want:    &ServiceProcess{Stale: `invalid pid "scheduler"`},
+		},
+		{
+			name:      "no pid file",
+			noPIDFile: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procRoot = t.TempDir()
+			paths.SERVICE_EXECUTABLE = t.TempDir()
+			writeProc(t, os.Getpid(), statLine(pid, "cli.test", "R", 1, 100), "cli.test")
+			if err := os.Symlink(strconv.Itoa(pid), filepath.Join(procRoot, "self")); err != nil {
+				t.Fatal(err)
+			}
+			if tt.stat != "" {
+				writeProc(t, pid, tt.stat, tt.args...)
+			}
+			if !tt.noPIDFile {
+				if err := os.WriteFile(paths.PID_PATH(c.SCHEDULER), []byte(tt.pidFile), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			got, err := readServiceProcess(c.SCHEDULER)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("readServiceProcess() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
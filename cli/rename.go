@@ -0,0 +1,396 @@
+package cli
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/aodr3w/keiji/runner"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+var taskNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+/*
+checkNewTaskName returns an error if name is not a valid task name or is
+already used by a task in TASKS_PATH or in the database
+*/
+func checkNewTaskName(name string) error {
+	if !taskNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid task name %q, names may only contain letters, digits, - and _", name)
+	}
+	exists, err := utils.PathExists(filepath.Join(paths.TASKS_PATH, name))
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("task %v already exists in %v", name, paths.TASKS_PATH)
+	}
+	task, err := findTask(name)
+	if err != nil {
+		return err
+	}
+	if task != nil {
+		return fmt.Errorf("task %v already exists in the database", name)
+	}
+	return nil
+}
+
+/*
+taskSlug returns the slug keiji-core derives from a task name, it names the task's log directory
+*/
+func taskSlug(name string) string {
+	return strings.Join(strings.Split(strings.ToLower(name), " "), "-")
+}
+
+/*
+cloneTask copies the source folder of the task named from, including its .env and
+schedule.go, into a new task named to. The clone is built separately
+*/
+func cloneTask(from string, to string, description string) error {
+	source := filepath.Join(paths.TASKS_PATH, from)
+	exists, err := utils.PathExists(source)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return cmdErrors.ErrTaskNotFound(from)
+	}
+	if err := checkNewTaskName(to); err != nil {
+		return err
+	}
+	target := filepath.Join(paths.TASKS_PATH, to)
+	err = filepath.WalkDir(source, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(filepath.Join(target, rel), info.Mode().Perm())
+		}
+		return utils.CopyFile(path, filepath.Join(target, rel), info.Mode().Perm())
+	})
+	if err == nil {
+		err = renameTaskEnv(to, to, description)
+	}
+	if err != nil {
+		os.RemoveAll(target)
+		return err
+	}
+	logInfo(fmt.Sprintf("cloned %v to %v, run `keiji task build %v` to schedule it", from, to, to))
+	return nil
+}
+
+/*
+renameTaskEnv sets TASK_NAME in the .env file of the task whose source folder is
+named dir, and TASK_DESCRIPTION when description is provided
+*/
+func renameTaskEnv(dir string, name string, description string) error {
+	env, err := readTaskEnv(dir)
+	if err != nil {
+		return err
+	}
+	env["TASK_NAME"] = name
+	if valid(description) {
+		env["TASK_DESCRIPTION"] = description
+	}
+	return writeTaskEnv(dir, env)
+}
+
+/*
+rollback undoes the completed steps of an operation in reverse order
+*/
+type rollback []func() error
+
+func (r *rollback) add(undo func() error) {
+	*r = append(*r, undo)
+}
+
+/*
+run undoes every step and returns err, extended with the steps that could not be undone
+*/
+func (r rollback) run(err error) error {
+	errs := []error{err}
+	for i := len(r) - 1; i >= 0; i-- {
+		if undoErr := r[i](); undoErr != nil {
+			errs = append(errs, fmt.Errorf("rollback failed: %v", undoErr))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+/*
+move renames from to to and registers the reverse rename
+*/
+func (r *rollback) move(from string, to string) error {
+	if err := os.Rename(from, to); err != nil {
+		return err
+	}
+	r.add(func() error { return os.Rename(to, from) })
+	return nil
+}
+
+/*
+renameDependsOn replaces from with to in the depends-on directive of the task's
+schedule.go, the original file is restored on rollback
+*/
+func (r *rollback) renameDependsOn(task string, from string, to string) error {
+	path := filepath.Join(paths.TASKS_PATH, task, "schedule.go")
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	lines := strings.Split(string(content), "\n")
+	changed := false
+	for i, line := range lines {
+		directive := directivePrefix + "depends-on"
+		trimmed := strings.TrimSpace(line)
+		if !strings.HasPrefix(trimmed, directive+" ") {
+			continue
+		}
+		upstream := strings.FieldsFunc(strings.TrimPrefix(trimmed, directive), func(r rune) bool { return r == ',' || r == ' ' })
+		for j, u := range upstream {
+			if u == from {
+				upstream[j] = to
+				changed = true
+			}
+		}
+		indent := line[:len(line)-len(strings.TrimLeft(line, " \t"))]
+		lines[i] = fmt.Sprintf("%v%v %v", indent, directive, strings.Join(upstream, " "))
+	}
+	if !changed {
+		return nil
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return err
+	}
+	r.add(func() error { return os.WriteFile(path, content, info.Mode().Perm()) })
+	logWarn(fmt.Sprintf("updated the depends-on directive of %v", task))
+	return nil
+}
+
+/*
+checkRenamable returns an error if the scheduler may be executing the task,
+disabled tasks and tasks in error state are ignored by the scheduler
+*/
+func checkRenamable(task *db.TaskModel) error {
+	pids, err := runner.RunInstances(task.Name)
+	if err != nil {
+		return err
+	}
+	if task.IsRunning || len(pids) > 0 {
+		return fmt.Errorf("task %v is running, wait for it to finish before renaming it", task.Name)
+	}
+	if task.IsDisabled || task.IsError {
+		return nil
+	}
+	if isServiceRunning(c.SCHEDULER) {
+		return fmt.Errorf("task %v is scheduled, disable it with `keiji task disable %v` before renaming it", task.Name, task.Name)
+	}
+	return nil
+}
+
+/*
+renameTask moves the source folder, executable, log directory, database record and
+history of the task named from to the name to. A failing step undoes the previous ones
+*/
+func renameTask(from string, to string) error {
+	source := filepath.Join(paths.TASKS_PATH, from)
+	exists, err := utils.PathExists(source)
+	if err != nil {
+		return err
+	}
+	task, err := findTask(from)
+	if err != nil {
+		return err
+	}
+	if task == nil && !exists {
+		return cmdErrors.ErrTaskNotFound(from)
+	}
+	if err := checkNewTaskName(to); err != nil {
+		return err
+	}
+	if task != nil {
+		if err := checkRenamable(task); err != nil {
+			return err
+		}
+	}
+	steps := rollback{}
+	if exists {
+		if err := steps.move(source, filepath.Join(paths.TASKS_PATH, to)); err != nil {
+			return err
+		}
+		env, err := readTaskEnv(to)
+		if err != nil {
+			return steps.run(err)
+		}
+		steps.add(func() error { return writeTaskEnv(to, env) })
+		if err := renameTaskEnv(to, to, ""); err != nil {
+			return steps.run(err)
+		}
+	}
+	entries, err := os.ReadDir(paths.TASKS_PATH)
+	if err != nil {
+		return steps.run(err)
+	}
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != to {
+			if err := steps.renameDependsOn(entry.Name(), from, to); err != nil && !os.IsNotExist(err) {
+				return steps.run(err)
+			}
+		}
+	}
+	if err := moveSecrets(from, to); err != nil {
+		return steps.run(err)
+	}
+	steps.add(func() error { return moveSecrets(to, from) })
+	if task == nil {
+		logInfo(fmt.Sprintf("renamed %v to %v", from, to))
+		return nil
+	}
+	executable, logPath, err := renameTaskFiles(&steps, task, to)
+	if err != nil {
+		return steps.run(err)
+	}
+	err = cmdRepo.DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Model(&db.TaskModel{}).Where("name = ?", from).Updates(map[string]interface{}{
+			"name":       to,
+			"slug":       taskSlug(to),
+			"executable": executable,
+			"log_path":   logPath,
+		}).Error
+		if err != nil {
+			return err
+		}
+		for _, model := range cliModels {
+			if err := tx.Model(model).Where("task_name = ?", from).Update("task_name", to).Error; err != nil {
+				return err
+			}
+		}
+		all := make([]*TaskSettingsModel, 0)
+		if err := tx.Find(&all).Error; err != nil {
+			return err
+		}
+		for _, settings := range all {
+			for i, u := range settings.DependsOn {
+				if u == from {
+					settings.DependsOn[i] = to
+					if err := tx.Save(settings).Error; err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return steps.run(err)
+	}
+	logInfo(fmt.Sprintf("renamed %v to %v", from, to))
+	return nil
+}
+
+/*
+renameTaskFiles moves the executables and log directory of task to the paths of
+the name to, and returns the new executable and log path
+*/
+func renameTaskFiles(steps *rollback, task *db.TaskModel, to string) (string, string, error) {
+	executable, err := utils.GetExecutable(to)
+	if err != nil {
+		return "", "", err
+	}
+	runFile := func(executable string) string {
+		return strings.TrimSuffix(executable, ".bin") + "_run.bin"
+	}
+	for from, target := range map[string]string{task.Executable: executable, runFile(task.Executable): runFile(executable)} {
+		if ok, _ := utils.PathExists(from); ok {
+			if err := steps.move(from, target); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	fromSlug, toSlug := taskSlug(task.Name), taskSlug(to)
+	logPath := filepath.Join(paths.TASK_LOG_DIR(toSlug), strings.Replace(filepath.Base(task.LogPath), fromSlug, toSlug, 1))
+	logDir := filepath.Dir(task.LogPath)
+	if ok, _ := utils.PathExists(logDir); !ok {
+		return executable, logPath, nil
+	}
+	if err := steps.move(logDir, paths.TASK_LOG_DIR(toSlug)); err != nil {
+		return "", "", err
+	}
+	//rotated log files share the slug prefix of the log file
+	entries, err := os.ReadDir(paths.TASK_LOG_DIR(toSlug))
+	if err != nil {
+		return "", "", err
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), fromSlug) {
+			dir := paths.TASK_LOG_DIR(toSlug)
+			if err := steps.move(filepath.Join(dir, entry.Name()), filepath.Join(dir, toSlug+strings.TrimPrefix(entry.Name(), fromSlug))); err != nil {
+				return "", "", err
+			}
+		}
+	}
+	return executable, logPath, nil
+}
+
+func newTaskCloneCMD() *cobra.Command {
+	var name, from, description string
+	cmd := &cobra.Command{
+		Use:   "clone NAME --from=SOURCE",
+		Short: "create a task from an existing one",
+		Long: "copies the source folder of SOURCE, including its .env and schedule.go, into a new task named NAME.\n" +
+			"The new task is saved to the database once built",
+		Example: "keiji task clone ping_bing --from=ping_google --desc=\"pings bing\"",
+		Args:    taskNameArgs(&name),
+		RunE: taskAction(func() error {
+			return cloneTask(from, name, description)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the new task")
+	cmd.Flags().StringVar(&from, "from", "", "name of the task to clone")
+	cmd.Flags().StringVar(&description, "desc", "", "description of the new task, defaults to the description of SOURCE")
+	cmd.MarkFlagRequired("from")
+	return cmd
+}
+
+func newTaskRenameCMD() *cobra.Command {
+	var name, to string
+	cmd := &cobra.Command{
+		Use:   "rename NAME --to=NEW_NAME",
+		Short: "rename a task",
+		Long: "moves the source folder, executable, log directory and database record of a task, keeping its run history.\n" +
+			"depends-on directives referencing the task are updated, every change is undone if a step fails.\n" +
+			"Scheduled tasks must be disabled first",
+		Example: "keiji task rename ping_google --to=ping_search",
+		Args:    taskNameArgs(&name),
+		RunE: taskAction(func() error {
+			return renameTask(name, to)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().StringVar(&to, "to", "", "new name of the task")
+	cmd.MarkFlagRequired("to")
+	return cmd
+}
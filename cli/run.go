@@ -0,0 +1,309 @@
+package cli
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/logging"
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/aodr3w/keiji/runner"
+	"github.com/spf13/cobra"
+)
+
+// runWaitDelay is how long an interrupted task may take to exit before it is killed
+const runWaitDelay = 10 * time.Second
+
+/*
+RunOptions configures a manual task run
+*/
+type RunOptions struct {
+	//Force runs the task even if it is disabled, in error or already running regardless of its overlap policy
+	Force bool
+	//Trigger is recorded in the task's run history, defaults to runner.TriggerManual
+	Trigger string
+	//Timeout overrides the task's timeout when set, 0 disables it
+	Timeout *time.Duration
+	//SkipDownstream prevents tasks depending on this task from being run after it succeeds
+	SkipDownstream bool
+}
+
+/*
+RunResult describes the outcome of a single execution of a task
+*/
+type RunResult struct {
+	Start    time.Time
+	End      time.Time
+	ExitCode int
+	Err      error
+	//TimedOut is true if the run was killed because it exceeded its timeout
+	TimedOut bool
+	//Interrupted is true if the run was stopped by a signal e.g ctrl+c or a replacing run
+	Interrupted bool
+	//Skipped is true if the run was refused by the overlap policy of the task
+	Skipped bool
+	//LogOffset is the size of the task's log file when the run started
+	LogOffset int64
+}
+
+/*
+Duration returns how long the execution took
+*/
+func (r *RunResult) Duration() time.Duration {
+	return r.End.Sub(r.Start)
+}
+
+/*
+checkRunnable returns an error if the task should not be run manually
+*/
+func checkRunnable(task *db.TaskModel, opts *RunOptions) error {
+	if opts.Force {
+		return nil
+	}
+	if task.IsDisabled {
+		return fmt.Errorf("task %v is disabled, provide --force to run it anyway", task.Name)
+	}
+	if task.IsError {
+		return fmt.Errorf("task %v is in error state (%v), provide --force to run it anyway", task.Name, task.ErrorTxt)
+	}
+	return nil
+}
+
+/*
+streamOutput copies lines from r to w and into the task's log file, masking secrets.
+The last line written is stored in last
+*/
+func streamOutput(r io.Reader, w io.Writer, logger *logging.Logger, mask *strings.Replacer, last *string, wg *sync.WaitGroup) {
+	defer wg.Done()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := mask.Replace(scanner.Text())
+		fmt.Fprintln(w, line)
+		logger.Info(line)
+		if len(strings.TrimSpace(line)) > 0 {
+			*last = line
+		}
+	}
+}
+
+/*
+executeTask runs the task's executable once, streaming its output to the
+terminal and to the task's log file. The run is interrupted when ctx is done.
+The runner inside the executable applies the task's timeout and records the
+run in the task's history
+*/
+func executeTask(ctx context.Context, task *db.TaskModel, opts *RunOptions) *RunResult {
+	result := &RunResult{Start: time.Now()}
+	defer func() {
+		result.End = time.Now()
+	}()
+	exists, err := utils.PathExists(task.Executable)
+	if err != nil || !exists {
+		result.ExitCode = -1
+		result.Err = fmt.Errorf("executable for task %v not found, run `keiji task build %v` first", task.Name, task.Name)
+		return result
+	}
+	if info, err := os.Stat(task.LogPath); err == nil {
+		result.LogOffset = info.Size()
+	}
+	logger, err := logging.NewFileLogger(task.LogPath)
+	if err != nil {
+		result.ExitCode = -1
+		result.Err = err
+		return result
+	}
+	env, secrets, err := runEnv(task.Name)
+	if err != nil {
+		result.ExitCode = -1
+		result.Err = err
+		return result
+	}
+	//secrets are masked in the output so that they never reach the terminal, logs or run history
+	mask := secretMasker(secrets)
+	cmd := exec.CommandContext(ctx, task.Executable, "--run")
+	//downstream tasks of manual runs are run by runDownstream
+	cmd.Env = append(env, fmt.Sprintf("%s=%s", runner.TriggerEnv, opts.Trigger), runner.SkipDownstreamEnv+"=1")
+	if opts.Timeout != nil {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%v", runner.TimeoutEnv, *opts.Timeout))
+	}
+	if opts.Force {
+		cmd.Env = append(cmd.Env, runner.ForceEnv+"=1")
+	}
+	//run from the source folder so that the task's .env is available
+	sourcePath := filepath.Join(paths.TASKS_PATH, task.Name)
+	if ok, _ := utils.PathExists(sourcePath); ok {
+		cmd.Dir = sourcePath
+	}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(syscall.SIGINT)
+	}
+	cmd.WaitDelay = runWaitDelay
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		result.ExitCode = -1
+		result.Err = err
+		return result
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		result.ExitCode = -1
+		result.Err = err
+		return result
+	}
+	//the secrets are passed over a pipe, the secrets key would stay readable in the environment of the run
+	sendSecrets := func() error { return nil }
+	if len(secrets) > 0 {
+		if sendSecrets, err = runner.PassSecrets(cmd, secrets); err != nil {
+			result.ExitCode = -1
+			result.Err = err
+			return result
+		}
+	}
+	logger.Info("manual run of task %v started", task.Name)
+	if err := cmd.Start(); err != nil {
+		sendSecrets()
+		result.ExitCode = -1
+		result.Err = err
+		return result
+	}
+	go sendSecrets()
+	var lastOut, lastErr string
+	wg := sync.WaitGroup{}
+	wg.Add(2)
+	go streamOutput(stdout, os.Stdout, logger, mask, &lastOut, &wg)
+	go streamOutput(stderr, os.Stderr, logger, mask, &lastErr, &wg)
+	wg.Wait()
+	err = cmd.Wait()
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+	if err != nil && ctx.Err() == nil && result.ExitCode == runner.ExitSkipped {
+		result.Skipped = true
+		result.Err = fmt.Errorf("skipped by its overlap policy")
+		return result
+	}
+	if err != nil && ctx.Err() == nil && result.ExitCode == runner.ExitTimeout {
+		result.TimedOut = true
+		result.Err = fmt.Errorf("%v", lastErr)
+		if record, ok := parseLogLine(lastErr); ok {
+			result.Err = fmt.Errorf("%v", record.Message)
+		}
+		logger.Error("manual run of task %v killed: %v", task.Name, result.Err)
+		return result
+	}
+	if err != nil && ctx.Err() != nil {
+		result.Interrupted = true
+		result.Err = fmt.Errorf("interrupted")
+		logger.Warn("manual run of task %v interrupted", task.Name)
+		return result
+	}
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			//tasks report the error returned by Function through log.Fatal
+			if record, ok := parseLogLine(lastErr); ok {
+				lastErr = record.Message
+			}
+			err = fmt.Errorf("exit status %d: %v", result.ExitCode, lastErr)
+		}
+		result.Err = err
+		logger.Error("manual run of task %v failed: %v", task.Name, err)
+		return result
+	}
+	logger.Info("manual run of task %v finished in %v", task.Name, time.Since(result.Start))
+	return result
+}
+
+/*
+recordRun saves the outcome of a run on the task record. An interrupted run
+does not flag the task IsError
+*/
+func recordRun(task *db.TaskModel, result *RunResult) error {
+	if result.Err != nil && !result.Interrupted {
+		_, err := cmdRepo.SetIsError(task.Name, true, result.Err.Error())
+		return err
+	}
+	if result.Err != nil {
+		return nil
+	}
+	return cmdRepo.DB.Model(&db.TaskModel{}).Where("name = ?", task.Name).Update("last_execution_time", result.Start.Truncate(time.Second)).Error
+}
+
+/*
+runTask executes the task named name once outside of its schedule
+*/
+func runTask(name string, opts RunOptions) error {
+	task, err := getTaskByName(name)
+	if err != nil {
+		return err
+	}
+	if err := checkRunnable(task, &opts); err != nil {
+		return err
+	}
+	if !valid(opts.Trigger) {
+		opts.Trigger = runner.TriggerManual
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	logWarn(fmt.Sprintf("running task %v", task.Name))
+	result := executeTask(ctx, task, &opts)
+	if result.Skipped {
+		return fmt.Errorf("task %v is already running, skipped by its overlap policy, provide --force to run it anyway", task.Name)
+	}
+	if err := recordRun(task, result); err != nil {
+		logError(err)
+	}
+	if result.TimedOut {
+		return cmdErrors.ErrTaskTimedOut(task.Name, result.Err)
+	}
+	if result.Err != nil {
+		return cmdErrors.ErrTaskFailed(task.Name, result.Err)
+	}
+	logInfo(fmt.Sprintf("ok (%v)", result.Duration().Truncate(time.Millisecond)))
+	if opts.SkipDownstream {
+		return nil
+	}
+	return runDownstream(task.Name)
+}
+
+func newTaskRunCMD() *cobra.Command {
+	var name string
+	var timeout time.Duration
+	opts := RunOptions{}
+	cmd := &cobra.Command{
+		Use:   "run NAME",
+		Short: "run a task now",
+		Long: "executes the task's built executable once outside of its schedule, streaming its output.\n" +
+			"The task executable retries failed runs according to the task's retry policy and runs exceeding the task's timeout are killed.\n" +
+			"Tasks depending on the task are run once all their upstream tasks have succeeded.\n" +
+			"The task executable handles a task that is already running according to its overlap policy, runs wait while MAX_CONCURRENCY tasks are running.\n" +
+			"Disabled tasks and tasks in error state are refused unless --force is provided",
+		Example: "keiji task run ping_google\nkeiji task run --name=ping_google --force --timeout=30s",
+		Args:    taskNameArgs(&name),
+	}
+	cmd.RunE = taskAction(func() error {
+		if cmd.Flags().Changed("timeout") {
+			opts.Timeout = &timeout
+		}
+		return runTask(name, opts)
+	})
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().BoolVar(&opts.Force, "force", false, "run the task even if it is disabled, in error or already running")
+	cmd.Flags().BoolVar(&opts.SkipDownstream, "skip-downstream", false, "do not run the tasks that depend on this task")
+	cmd.Flags().DurationVar(&timeout, "timeout", 0, "kill the run after this duration instead of the task's timeout, 0 disables it")
+	return cmd
+}
@@ -0,0 +1,273 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/utils"
+	"github.com/aodr3w/keiji/runner"
+	"github.com/spf13/cobra"
+)
+
+/*
+TaskSchedule computes the fire times of a task
+*/
+type TaskSchedule interface {
+	//Next returns the first fire time strictly after t
+	Next(t time.Time) time.Time
+}
+
+/*
+intervalSchedule fires every step, aligned on anchor when it is known
+*/
+type intervalSchedule struct {
+	step   time.Duration
+	anchor *time.Time
+}
+
+func (s *intervalSchedule) Next(t time.Time) time.Time {
+	if s.anchor == nil {
+		return t.Add(s.step)
+	}
+	if s.anchor.After(t) {
+		return *s.anchor
+	}
+	steps := t.Sub(*s.anchor)/s.step + 1
+	return s.anchor.Add(steps * s.step)
+}
+
+/*
+dayTimeSchedule fires once a week on day at hour:minute in loc
+*/
+type dayTimeSchedule struct {
+	day    time.Weekday
+	hour   int
+	minute int
+	loc    *time.Location
+}
+
+func (s *dayTimeSchedule) Next(t time.Time) time.Time {
+	t = t.In(s.loc)
+	next := time.Date(t.Year(), t.Month(), t.Day(), s.hour, s.minute, 0, 0, s.loc)
+	next = next.AddDate(0, 0, (int(s.day)-int(next.Weekday())+7)%7)
+	if !next.After(t) {
+		next = next.AddDate(0, 0, 7)
+	}
+	return next
+}
+
+/*
+parseScheduleInfo splits a persisted schedule e.g `units:seconds,interval:10`
+or `day:Friday,time:10:00PM` into its fields
+*/
+func parseScheduleInfo(schedule string) map[string]string {
+	info := make(map[string]string)
+	for _, field := range strings.Split(schedule, ",") {
+		key, value, ok := strings.Cut(field, ":")
+		if ok {
+			info[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return info
+}
+
+var intervalUnits = map[string]time.Duration{
+	"seconds": time.Second,
+	"minutes": time.Minute,
+	"hours":   time.Hour,
+}
+
+/*
+hmsInterval returns the interval of a task stored with an HMS schedule
+*/
+func hmsInterval(task *db.TaskModel) (time.Duration, error) {
+	info := parseScheduleInfo(task.Schedule)
+	unit, ok := intervalUnits[info["units"]]
+	if !ok {
+		return 0, fmt.Errorf("task %v: invalid interval units in schedule %q", task.Name, task.Schedule)
+	}
+	n, err := strconv.Atoi(info["interval"])
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("task %v: invalid interval in schedule %q", task.Name, task.Schedule)
+	}
+	return time.Duration(n) * unit, nil
+}
+
+/*
+newTaskSchedule builds the schedule of task from its stored Schedule & Type,
+or from the cron expression in its settings when it declares one
+*/
+func newTaskSchedule(task *db.TaskModel, settings *TaskSettingsModel, loc *time.Location) (TaskSchedule, error) {
+	if valid(settings.Cron) {
+		schedule, err := runner.ParseCron(settings.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("task %v: %v", task.Name, err)
+		}
+		return &cronSchedule{schedule: schedule, loc: loc}, nil
+	}
+	info := parseScheduleInfo(task.Schedule)
+	switch task.Type {
+	case db.HMSTask:
+		step, err := hmsInterval(task)
+		if err != nil {
+			return nil, err
+		}
+		anchor := task.NextExecutionTime
+		if anchor == nil {
+			anchor = task.LastExecutionTime
+		}
+		return &intervalSchedule{step: step, anchor: anchor}, nil
+	case db.DayTimeTask:
+		day := -1
+		for d := time.Sunday; d <= time.Saturday; d++ {
+			if strings.EqualFold(d.String(), info["day"]) {
+				day = int(d)
+			}
+		}
+		if day < 0 {
+			return nil, fmt.Errorf("task %v: invalid day in schedule %q", task.Name, task.Schedule)
+		}
+		if !valid(info["time"]) {
+			return nil, fmt.Errorf("task %v: missing time in schedule %q", task.Name, task.Schedule)
+		}
+		at, err := utils.ParseTimeStr(info["time"])
+		if err != nil {
+			return nil, fmt.Errorf("task %v: %v", task.Name, err)
+		}
+		return &dayTimeSchedule{day: time.Weekday(day), hour: at.Hour(), minute: at.Minute(), loc: loc}, nil
+	default:
+		return nil, fmt.Errorf("task %v: unsupported schedule type %q", task.Name, task.Type)
+	}
+}
+
+/*
+workspaceLocation returns the TIME_ZONE configured in the workspace settings
+*/
+func workspaceLocation() (*time.Location, error) {
+	return runner.Location()
+}
+
+/*
+FireTime is a single upcoming execution of a task
+*/
+type FireTime struct {
+	Task string    `json:"task" yaml:"task"`
+	Time time.Time `json:"time" yaml:"time"`
+}
+
+/*
+upcomingRuns returns the next count fire times of task after from
+*/
+func upcomingRuns(task *db.TaskModel, settings *TaskSettingsModel, from time.Time, count int, loc *time.Location) ([]FireTime, error) {
+	schedule, err := newTaskSchedule(task, settings, loc)
+	if err != nil {
+		return nil, err
+	}
+	runs := make([]FireTime, 0, count)
+	t := from
+	for i := 0; i < count; i++ {
+		t = schedule.Next(t)
+		runs = append(runs, FireTime{Task: task.Name, Time: t.In(loc)})
+	}
+	return runs, nil
+}
+
+/*
+previewSchedule prints the next count fire times of the task named name,
+or a merged timeline of all runnable tasks when name is empty
+*/
+func previewSchedule(name string, count int) error {
+	if count < 1 {
+		return fmt.Errorf("--count must be at least 1")
+	}
+	loc, err := workspaceLocation()
+	if err != nil {
+		return err
+	}
+	var tasks []*db.TaskModel
+	if valid(name) {
+		task, err := getTaskByName(name)
+		if err != nil {
+			return err
+		}
+		tasks = append(tasks, task)
+	} else {
+		all, err := cmdRepo.GetAllTasks()
+		if err != nil {
+			return err
+		}
+		//disabled tasks and tasks in error state are ignored by the scheduler
+		for _, task := range all {
+			if !task.IsDisabled && !task.IsError {
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	settings, err := getAllTaskSettings()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	runs := make([]FireTime, 0)
+	for _, task := range tasks {
+		taskSettings, ok := settings[task.Name]
+		if !ok {
+			taskSettings = runner.NewTaskSettings(task.Name)
+		}
+		taskRuns, err := upcomingRuns(task, taskSettings, now, count, loc)
+		if err != nil {
+			if valid(name) {
+				return err
+			}
+			logWarn(err)
+			continue
+		}
+		runs = append(runs, taskRuns...)
+	}
+	sort.SliceStable(runs, func(i, j int) bool {
+		return runs[i].Time.Before(runs[j].Time)
+	})
+	if len(runs) > count {
+		runs = runs[:count]
+	}
+	return printOutput("FireTimeList", runs, func(w io.Writer, wide bool) {
+		fmt.Fprintf(w, "TASK\tTIME (%v)\tIN\n", loc)
+		for _, run := range runs {
+			fmt.Fprintf(w, "%s\t%s\t%s\n", run.Task, run.Time.Format("Mon 2006-01-02 15:04:05"), run.Time.Sub(now).Truncate(time.Second))
+		}
+	})
+}
+
+func newTaskNextCMD() *cobra.Command {
+	var name string
+	var count int
+	var all bool
+	cmd := &cobra.Command{
+		Use:   "next [NAME]",
+		Short: "preview upcoming executions",
+		Long: "computes the next fire times of a task from its stored schedule in the workspace TIME_ZONE.\n" +
+			"With --all a merged timeline of every task that is neither disabled nor in error state is printed",
+		Example: "keiji task next ping_google --count=10\nkeiji task next --all",
+		Args: func(cmd *cobra.Command, args []string) error {
+			if all {
+				if len(args) > 0 || valid(name) {
+					return fmt.Errorf("--all cannot be combined with a task name")
+				}
+				return nil
+			}
+			return taskNameArgs(&name)(cmd, args)
+		},
+		RunE: taskAction(func() error {
+			return previewSchedule(name, count)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().IntVar(&count, "count", 5, "number of fire times to print")
+	cmd.Flags().BoolVar(&all, "all", false, "print a merged timeline of all tasks that are not disabled or in error state")
+	return cmd
+}
@@ -0,0 +1,280 @@
+package cli
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji/runner"
+)
+
+func TestIntervalScheduleNext(t *testing.T) {
+	anchor := time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name     string
+		schedule intervalSchedule
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "no anchor",
+			schedule: intervalSchedule{step: 30 * time.Second},
+			from:     time.Date(2026, time.March, 6, 10, 0, 10, 0, time.UTC),
+			want:     time.Date(2026, time.March, 6, 10, 0, 40, 0, time.UTC),
+		},
+		{
+			name:     "anchor in the future",
+			schedule: intervalSchedule{step: time.Minute, anchor: &anchor},
+			from:     time.Date(2026, time.March, 6, 9, 58, 0, 0, time.UTC),
+			want:     anchor,
+		},
+		{
+			name:     "aligned on the anchor",
+			schedule: intervalSchedule{step: 15 * time.Minute, anchor: &anchor},
+			from:     time.Date(2026, time.March, 6, 10, 20, 0, 0, time.UTC),
+			want:     time.Date(2026, time.March, 6, 10, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "strictly after a fire time",
+			schedule: intervalSchedule{step: 15 * time.Minute, anchor: &anchor},
+			from:     time.Date(2026, time.March, 6, 10, 30, 0, 0, time.UTC),
+			want:     time.Date(2026, time.March, 6, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name:     "strictly after the anchor",
+			schedule: intervalSchedule{step: time.Hour, anchor: &anchor},
+			from:     anchor,
+			want:     time.Date(2026, time.March, 6, 11, 0, 0, 0, time.UTC),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDayTimeScheduleNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	tests := []struct {
+		name     string
+		schedule dayTimeSchedule
+		from     time.Time
+		want     time.Time
+	}{
+		{
+			name:     "later the same day",
+			schedule: dayTimeSchedule{day: time.Friday, hour: 22, minute: 0, loc: time.UTC},
+			from:     time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC), //a Friday
+			want:     time.Date(2026, time.March, 6, 22, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "earlier the same day",
+			schedule: dayTimeSchedule{day: time.Friday, hour: 9, minute: 30, loc: time.UTC},
+			from:     time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC),
+			want:     time.Date(2026, time.March, 13, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "at the fire time",
+			schedule: dayTimeSchedule{day: time.Friday, hour: 10, minute: 0, loc: time.UTC},
+			from:     time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC),
+			want:     time.Date(2026, time.March, 13, 10, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "later in the week",
+			schedule: dayTimeSchedule{day: time.Monday, hour: 8, minute: 15, loc: time.UTC},
+			from:     time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC),
+			want:     time.Date(2026, time.March, 9, 8, 15, 0, 0, time.UTC),
+		},
+		{
+			name:     "day matched in TIME_ZONE",
+			schedule: dayTimeSchedule{day: time.Friday, hour: 22, minute: 0, loc: newYork},
+			from:     time.Date(2026, time.March, 7, 2, 0, 0, 0, time.UTC), //Friday 21:00 EST
+			want:     time.Date(2026, time.March, 7, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "across the start of DST",
+			schedule: dayTimeSchedule{day: time.Monday, hour: 9, minute: 0, loc: newYork},
+			from:     time.Date(2026, time.March, 6, 12, 0, 0, 0, newYork),
+			want:     time.Date(2026, time.March, 9, 13, 0, 0, 0, time.UTC), //09:00 EDT
+		},
+		{
+			name:     "across the end of DST",
+			schedule: dayTimeSchedule{day: time.Monday, hour: 9, minute: 0, loc: newYork},
+			from:     time.Date(2026, time.October, 30, 12, 0, 0, 0, newYork),
+			want:     time.Date(2026, time.November, 2, 14, 0, 0, 0, time.UTC), //09:00 EST
+		},
+		{
+			name:     "a week later across DST",
+			schedule: dayTimeSchedule{day: time.Sunday, hour: 12, minute: 0, loc: newYork},
+			from:     time.Date(2026, time.March, 1, 13, 0, 0, 0, newYork),
+			want:     time.Date(2026, time.March, 8, 16, 0, 0, 0, time.UTC), //12:00 EDT
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewTaskSchedule(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	from := time.Date(2026, time.March, 6, 10, 7, 30, 0, time.UTC) //a Friday
+	tests := []struct {
+		name    string
+		task    db.TaskModel
+		cron    string
+		loc     *time.Location
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "hms seconds",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:10"},
+			loc:  time.UTC,
+			want: from.Add(10 * time.Second),
+		},
+		{
+			name: "hms hours",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:hours,interval:2"},
+			loc:  time.UTC,
+			want: from.Add(2 * time.Hour),
+		},
+		{
+			name: "daytime in TIME_ZONE",
+			task: db.TaskModel{Type: db.DayTimeTask, Schedule: "day:friday,time:10:00PM"},
+			loc:  newYork,
+			want: time.Date(2026, time.March, 7, 3, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cron replaces the hms poll",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:10"},
+			cron: "0 9 * * *",
+			loc:  newYork,
+			want: time.Date(2026, time.March, 6, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "cron across the start of DST",
+			task: db.TaskModel{Type: db.HMSTask, Schedule: "units:minutes,interval:1"},
+			cron: "0 9 * * MON",
+			loc:  newYork,
+			want: time.Date(2026, time.March, 9, 13, 0, 0, 0, time.UTC),
+		},
+		{
+			name:    "invalid cron",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:10"},
+			cron:    "0 9 * *",
+			loc:     time.UTC,
+			wantErr: true,
+		},
+		{
+			name:    "invalid hms units",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:days,interval:1"},
+			loc:     time.UTC,
+			wantErr: true,
+		},
+		{
+			name:    "invalid hms interval",
+			task:    db.TaskModel{Type: db.HMSTask, Schedule: "units:seconds,interval:0"},
+			loc:     time.UTC,
+			wantErr: true,
+		},
+		{
+			name:    "invalid day",
+			task:    db.TaskModel{Type: db.DayTimeTask, Schedule: "day:someday,time:10:00PM"},
+			loc:     time.UTC,
+			wantErr: true,
+		},
+		{
+			name:    "missing time",
+			task:    db.TaskModel{Type: db.DayTimeTask, Schedule: "day:friday"},
+			loc:     time.UTC,
+			wantErr: true,
+		},
+		{
+			name:    "unknown type",
+			task:    db.TaskModel{Type: "Cron", Schedule: "cron:0 9 * * *"},
+			loc:     time.UTC,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.task.Name = "report"
+			settings := runner.NewTaskSettings(tt.task.Name)
+			settings.Cron = tt.cron
+			schedule, err := newTaskSchedule(&tt.task, settings, tt.loc)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newTaskSchedule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpcomingRuns(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	task := &db.TaskModel{Name: "report", Type: db.DayTimeTask, Schedule: "day:saturday,time:09:00AM"}
+	from := time.Date(2026, time.October, 24, 12, 0, 0, 0, newYork)
+	runs, err := upcomingRuns(task, runner.NewTaskSettings(task.Name), from, 3, newYork)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []time.Time{
+		time.Date(2026, time.October, 31, 13, 0, 0, 0, time.UTC), //09:00 EDT
+		time.Date(2026, time.November, 7, 14, 0, 0, 0, time.UTC), //09:00 EST
+		time.Date(2026, time.November, 14, 14, 0, 0, 0, time.UTC),
+	}
+	if len(runs) != len(want) {
+		t.Fatalf("upcomingRuns() returned %d runs, want %d", len(runs), len(want))
+	}
+	for i, run := range runs {
+		if run.Task != task.Name || !run.Time.Equal(want[i]) || run.Time.Location() != newYork {
+			t.Errorf("run %d = %v %v, want %v %v in %v", i, run.Task, run.Time, task.Name, want[i], newYork)
+		}
+	}
+}
+
+func TestWorkspaceLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		want     string
+		wantErr  bool
+	}{
+		{name: "unset", timeZone: "", want: time.Local.String()},
+		{name: "iana name", timeZone: "America/New_York", want: "America/New_York"},
+		{name: "unknown", timeZone: "Nowhere/Town", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TIME_ZONE", tt.timeZone)
+			loc, err := workspaceLocation()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("workspaceLocation() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && loc.String() != tt.want {
+				t.Errorf("workspaceLocation() = %v, want %v", loc, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package cli
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/aodr3w/keiji/runner"
+)
+
+// secretMask replaces secret values wherever they would be printed
+const secretMask = runner.SecretMask
+
+/*
+updateSecretStore applies update to the secrets store under an exclusive lock,
+the store is written to a temporary file renamed over SECRETS_PATH so that
+runs never read a partially written store. Nothing is written when update
+returns false
+*/
+func updateSecretStore(update func(store map[string]map[string]string) (bool, error)) error {
+	if err := os.MkdirAll(filepath.Dir(runner.SECRETS_PATH), 0755); err != nil {
+		return err
+	}
+	lock, err := os.OpenFile(runner.SECRETS_PATH+".lock", os.O_CREATE|os.O_RDWR, 0600)
+	if err != nil {
+		return err
+	}
+	defer lock.Close()
+	if err := syscall.Flock(int(lock.Fd()), syscall.LOCK_EX); err != nil {
+		return err
+	}
+	defer syscall.Flock(int(lock.Fd()), syscall.LOCK_UN)
+	store, err := runner.ReadSecretStore()
+	if err != nil {
+		return err
+	}
+	changed, err := update(store)
+	if err != nil || !changed {
+		return err
+	}
+	content, err := json.MarshalIndent(store, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(runner.SECRETS_PATH), ".secrets-*.json")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(content); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), runner.SECRETS_PATH)
+}
+
+/*
+setSecret encrypts value and stores it as the secret key of the task named name,
+the secrets key is created on the first secret unless it is provided through
+KEIJI_SECRETS_KEY
+*/
+func setSecret(name string, key string, value string) error {
+	if os.Getenv(runner.SecretsKeyEnv) == "" {
+		if err := runner.CreateSecretsKey(); err != nil {
+			return err
+		}
+	}
+	aead, err := runner.SecretsCipher()
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	sealed := base64.StdEncoding.EncodeToString(aead.Seal(nonce, nonce, []byte(value), nil))
+	return updateSecretStore(func(store map[string]map[string]string) (bool, error) {
+		if store[name] == nil {
+			store[name] = make(map[string]string)
+		}
+		store[name][key] = sealed
+		return true, nil
+	})
+}
+
+/*
+unsetSecret removes the secret key of the task named name, it returns false if it did not exist
+*/
+func unsetSecret(name string, key string) (bool, error) {
+	removed := false
+	err := updateSecretStore(func(store map[string]map[string]string) (bool, error) {
+		if _, ok := store[name][key]; !ok {
+			return false, nil
+		}
+		delete(store[name], key)
+		if len(store[name]) == 0 {
+			delete(store, name)
+		}
+		removed = true
+		return true, nil
+	})
+	return removed, err
+}
+
+/*
+moveSecrets moves the secrets of the task named from to the task named to,
+the secrets of from are deleted when to is empty
+*/
+func moveSecrets(from string, to string) error {
+	return updateSecretStore(func(store map[string]map[string]string) (bool, error) {
+		secrets, ok := store[from]
+		if !ok {
+			return false, nil
+		}
+		delete(store, from)
+		if valid(to) {
+			store[to] = secrets
+		}
+		return true, nil
+	})
+}
+
+/*
+secretMasker replaces the values of secrets with secretMask
+*/
+func secretMasker(secrets map[string]string) *strings.Replacer {
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, value := range secrets {
+		if valid(value) {
+			pairs = append(pairs, value, secretMask)
+		}
+	}
+	return strings.NewReplacer(pairs...)
+}
@@ -0,0 +1,77 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/aodr3w/keiji/runner"
+)
+
+func TestSecretStoreUpdates(t *testing.T) {
+	defer func(path, keyPath string) {
+		runner.SECRETS_PATH, runner.SECRETS_KEY_PATH = path, keyPath
+	}(runner.SECRETS_PATH, runner.SECRETS_KEY_PATH)
+	dir := t.TempDir()
+	runner.SECRETS_PATH = filepath.Join(dir, "secrets.json")
+	runner.SECRETS_KEY_PATH = filepath.Join(dir, "secrets.key")
+	t.Setenv(runner.SecretsKeyEnv, "")
+	tests := []struct {
+		name   string
+		update func() error
+		task   string
+		want   map[string]string
+	}{
+		{
+			name:   "set creates the key and store",
+			update: func() error { return setSecret("report", "SMTP_PASSWORD", "hunter2") },
+			task:   "report",
+			want:   map[string]string{"SMTP_PASSWORD": "hunter2"},
+		},
+		{
+			name:   "set another secret",
+			update: func() error { return setSecret("report", "API_TOKEN", "abc") },
+			task:   "report",
+			want:   map[string]string{"SMTP_PASSWORD": "hunter2", "API_TOKEN": "abc"},
+		},
+		{
+			name: "unset a secret",
+			update: func() error {
+				_, err := unsetSecret("report", "API_TOKEN")
+				return err
+			},
+			task: "report",
+			want: map[string]string{"SMTP_PASSWORD": "hunter2"},
+		},
+		{
+			name:   "move to a renamed task",
+			update: func() error { return moveSecrets("report", "weekly_report") },
+			task:   "weekly_report",
+			want:   map[string]string{"SMTP_PASSWORD": "hunter2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.update(); err != nil {
+				t.Fatal(err)
+			}
+			got, err := runner.TaskSecrets(tt.task)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("TaskSecrets(%v) = %v, want %v", tt.task, got, tt.want)
+			}
+		})
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if filepath.Ext(entry.Name()) == ".json" && entry.Name() != "secrets.json" {
+			t.Errorf("temporary store %v was left behind", entry.Name())
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji/runner"
+)
+
+// directivePrefix marks comments in a task's schedule.go that configure the task e.g `//keiji:retry attempts=3`
+const directivePrefix = "//keiji:"
+
+/*
+newRetryPolicy parses the arguments of a retry directive
+e.g `attempts=3 backoff=exponential delay=10s jitter=0.2 fatal="401|403"`
+*/
+func newRetryPolicy(args string) (runner.Retry, error) {
+	policy := runner.NewTaskSettings("").Retry
+	values, ok := parseLogValues(args)
+	if !ok {
+		return policy, fmt.Errorf("invalid retry directive %q, expected key=value pairs", args)
+	}
+	var err error
+	for key, value := range values {
+		switch key {
+		case "attempts":
+			policy.Attempts, err = strconv.Atoi(value)
+		case "backoff":
+			policy.Backoff = strings.ToLower(value)
+		case "delay":
+			policy.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			policy.Jitter, err = strconv.ParseFloat(value, 64)
+		case "retry-on":
+			policy.RetryOn = value
+		case "fatal":
+			policy.Fatal = value
+		default:
+			return policy, fmt.Errorf("unknown retry option %q, valid options: attempts, backoff, delay, jitter, retry-on, fatal", key)
+		}
+		if err != nil {
+			return policy, fmt.Errorf("invalid retry option %v=%v: %v", key, value, err)
+		}
+	}
+	return policy, policy.Validate()
+}
+
+var overlapPolicies = []string{runner.OverlapSkip, runner.OverlapQueue, runner.OverlapAllow, runner.OverlapReplace}
+
+func parseOverlap(args string) (string, error) {
+	policy := strings.ToLower(strings.TrimSpace(args))
+	if !containsFold(overlapPolicies, policy) {
+		return "", fmt.Errorf("invalid overlap policy %q, must be one of %s", args, strings.Join(overlapPolicies, ", "))
+	}
+	return policy, nil
+}
+
+/*
+readDirectives returns the arguments of the //keiji: directives declared in the
+task's schedule.go, keyed by directive name
+*/
+func readDirectives(name string) (map[string]string, error) {
+	f, err := os.Open(filepath.Join(paths.TASKS_PATH, name, "schedule.go"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	directives := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, directivePrefix) {
+			continue
+		}
+		directive, args, _ := strings.Cut(strings.TrimPrefix(line, directivePrefix), " ")
+		if _, ok := directives[directive]; ok {
+			return nil, fmt.Errorf("directive %v%v is declared more than once", directivePrefix, directive)
+		}
+		directives[directive] = strings.TrimSpace(args)
+	}
+	return directives, scanner.Err()
+}
+
+/*
+readTaskSettings parses the settings declared in the task's schedule.go
+*/
+func readTaskSettings(name string) (*TaskSettingsModel, error) {
+	settings := runner.NewTaskSettings(name)
+	directives, err := readDirectives(name)
+	if err != nil {
+		return nil, err
+	}
+	for directive, args := range directives {
+		switch directive {
+		case "retry":
+			if settings.Retry, err = newRetryPolicy(args); err != nil {
+				return nil, err
+			}
+		case "overlap":
+			if settings.Overlap, err = parseOverlap(args); err != nil {
+				return nil, err
+			}
+		case "depends-on":
+			if settings.DependsOn, err = parseDependsOn(name, args); err != nil {
+				return nil, err
+			}
+		case "timeout":
+			if settings.Timeout, err = time.ParseDuration(args); err != nil || settings.Timeout < 0 {
+				return nil, fmt.Errorf("invalid timeout %q, expected a duration such as 30s or 5m", args)
+			}
+		case "cron":
+			if settings.Cron, err = parseCronDirective(args); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, fmt.Errorf("unknown directive %v%v", directivePrefix, directive)
+		}
+	}
+	return settings, nil
+}
+
+/*
+saveTaskSettings replaces the stored settings of a task
+*/
+func saveTaskSettings(settings *TaskSettingsModel) error {
+	existing := TaskSettingsModel{}
+	//Find is used instead of First so that a missing row is not logged as an error
+	err := cmdRepo.DB.Where("task_name = ?", settings.TaskName).Limit(1).Find(&existing).Error
+	if err != nil {
+		return err
+	}
+	settings.ID = existing.ID
+	settings.CreatedAt = existing.CreatedAt
+	return cmdRepo.DB.Save(settings).Error
+}
+
+/*
+getAllTaskSettings returns the stored settings of every task keyed by task name
+*/
+func getAllTaskSettings() (map[string]*TaskSettingsModel, error) {
+	all := make([]*TaskSettingsModel, 0)
+	if err := cmdRepo.DB.Find(&all).Error; err != nil {
+		return nil, err
+	}
+	settings := make(map[string]*TaskSettingsModel, len(all))
+	for _, s := range all {
+		settings[s.TaskName] = s
+	}
+	return settings, nil
+}
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bufio"
+	"bytes"
+	"debug/buildinfo"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aodr3w/keiji-core/bus"
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji/runner"
+)
+
+// clockTicks is USER_HZ, the unit of cpu & start times in /proc/<pid>/stat, it is 100 on every Linux architecture
+const clockTicks = 100
+
+// portTimeout is how long the status waits for a port of the bus to accept a connection
+const portTimeout = 500 * time.Millisecond
+
+// maxLastLogWidth truncates the last log line of services in the wide status table
+const maxLastLogWidth = 60
+
+// lastLogLineSize is how much of the end of a log file is read to find its last line
+const lastLogLineSize = 4096
+
+// servicePorts lists the ports a service listens on, they are checked by `keiji system status`
+var servicePorts = map[c.Service][]string{
+	c.TCP_BUS: {bus.PUSH_PORT, bus.PULL_PORT},
+}
+
+// health of a running service
+const (
+	Healthy   = "healthy"
+	Unhealthy = "unhealthy"
+)
+
+/*
+PortView reports whether a port of a service accepts connections
+*/
+type PortView struct {
+	Port      string `json:"port" yaml:"port"`
+	Reachable bool   `json:"reachable" yaml:"reachable"`
+}
+
+/*
+TaskSummary counts the tasks by state, a task may be both running and disabled
+*/
+type TaskSummary struct {
+	Total    int `json:"total" yaml:"total"`
+	Running  int `json:"running" yaml:"running"`
+	Queued   int `json:"queued" yaml:"queued"`
+	Error    int `json:"error" yaml:"error"`
+	Disabled int `json:"disabled" yaml:"disabled"`
+	//MaxConcurrency is the MAX_CONCURRENCY setting, 0 means unlimited
+	MaxConcurrency int      `json:"maxConcurrency" yaml:"maxConcurrency"`
+	RunningTasks   []string `json:"runningTasks" yaml:"runningTasks"`
+	QueuedTasks    []string `json:"queuedTasks" yaml:"queuedTasks"`
+	ErrorTasks     []string `json:"errorTasks" yaml:"errorTasks"`
+}
+
+func newTaskSummary() (*TaskSummary, error) {
+	max, err := runner.MaxConcurrency()
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return nil, err
+	}
+	summary := &TaskSummary{
+		Total:          len(tasks),
+		MaxConcurrency: max,
+		RunningTasks:   make([]string, 0),
+		QueuedTasks:    make([]string, 0),
+		ErrorTasks:     make([]string, 0),
+	}
+	for _, task := range tasks {
+		if task.IsRunning {
+			summary.RunningTasks = append(summary.RunningTasks, task.Name)
+		}
+		if task.IsQueued {
+			summary.QueuedTasks = append(summary.QueuedTasks, task.Name)
+		}
+		if task.IsError {
+			summary.ErrorTasks = append(summary.ErrorTasks, task.Name)
+		}
+		if task.IsDisabled {
+			summary.Disabled++
+		}
+	}
+	summary.Running, summary.Queued, summary.Error = len(summary.RunningTasks), len(summary.QueuedTasks), len(summary.ErrorTasks)
+	return summary, nil
+}
+
+/*
+printTaskSummary prints the task counts below the services table
+*/
+func printTaskSummary(w io.Writer, summary *TaskSummary, wide bool) {
+	limit := "unlimited"
+	if summary.MaxConcurrency > 0 {
+		limit = strconv.Itoa(summary.MaxConcurrency)
+	}
+	fmt.Fprintf(w, "\ntasks: %d total, %d running, %d queued, %d error, %d disabled, MAX_CONCURRENCY: %v\n",
+		summary.Total, summary.Running, summary.Queued, summary.Error, summary.Disabled, limit)
+	if wide {
+		fmt.Fprintf(w, "running: %v\nqueued: %v\nerror: %v\n",
+			strings.Join(summary.RunningTasks, ", "), strings.Join(summary.QueuedTasks, ", "), strings.Join(summary.ErrorTasks, ", "))
+	}
+}
+
+/*
+serviceVersion returns the module version the executable of service was built from
+*/
+func serviceVersion(service c.Service) string {
+	executable, err := getServicePath(service)
+	if err != nil {
+		return ""
+	}
+	info, err := buildinfo.ReadFile(executable)
+	if err != nil {
+		return ""
+	}
+	return info.Main.Version
+}
+
+/*
+bootTime returns when the system booted, read from /proc/stat
+*/
+func bootTime() (time.Time, error) {
+	f, err := os.Open(filepath.Join(procRoot, "stat"))
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "btime "); ok {
+			btime, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+			if err != nil {
+				return time.Time{}, err
+			}
+			return time.Unix(btime, 0), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("btime not found in %v", f.Name())
+}
+
+/*
+procUsage returns when process pid started, its resident memory in bytes and the
+cpu time it used, read from /proc
+*/
+func procUsage(pid int) (time.Time, uint64, time.Duration, error) {
+	status, err := procStat(pid)
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	boot, err := bootTime()
+	if err != nil {
+		return time.Time{}, 0, 0, err
+	}
+	started := boot.Add(time.Duration(status.Start) * time.Second / clockTicks)
+	cpu := time.Duration(status.UTime+status.STime) * time.Second / clockTicks
+	content, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "status"))
+	if err != nil {
+		return started, 0, cpu, err
+	}
+	var rss uint64
+	for _, line := range strings.Split(string(content), "\n") {
+		if value, ok := strings.CutPrefix(line, "VmRSS:"); ok {
+			kb, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimSpace(value), " kB"), 10, 64)
+			if err != nil {
+				return started, 0, cpu, err
+			}
+			rss = kb * 1024
+		}
+	}
+	return started, rss, cpu, nil
+}
+
+/*
+checkPorts dials the ports of service on localhost
+*/
+func checkPorts(service c.Service) []PortView {
+	ports := make([]PortView, 0, len(servicePorts[service]))
+	for _, port := range servicePorts[service] {
+		conn, err := net.DialTimeout("tcp", "localhost"+port, portTimeout)
+		if err == nil {
+			conn.Close()
+		}
+		ports = append(ports, PortView{Port: port, Reachable: err == nil})
+	}
+	return ports
+}
+
+/*
+lastLogLine returns the last non empty line of the log file at path
+*/
+func lastLogLine(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+	offset := max(info.Size()-lastLogLineSize, 0)
+	buf := make([]byte, info.Size()-offset)
+	if _, err := f.ReadAt(buf, offset); err != nil && err != io.EOF {
+		return "", err
+	}
+	buf = bytes.TrimRight(buf, "\r\n ")
+	if i := bytes.LastIndexByte(buf, '\n'); i >= 0 {
+		buf = buf[i+1:]
+	}
+	return strings.TrimSpace(string(buf)), nil
+}
+
+/*
+formatBytes renders a size in bytes with a binary unit e.g 12.5MiB
+*/
+func formatBytes(size uint64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%dB", size)
+	}
+	div, exp := uint64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,283 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/paths"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/aodr3w/keiji/runner"
+)
+
+// stopEscalationDelay is how long --force waits after SIGTERM before sending SIGKILL
+const stopEscalationDelay = 5 * time.Second
+
+// stopPollInterval is how often a stopping service and its tasks are checked
+const stopPollInterval = 200 * time.Millisecond
+
+/*
+StopOptions controls how `keiji system stop` stops a service
+*/
+type StopOptions struct {
+	//Timeout is how long the service and its running tasks are given to exit after SIGINT
+	Timeout time.Duration
+	//Force escalates to SIGTERM then SIGKILL once Timeout elapses
+	Force bool
+}
+
+/*
+trackedProcess is a process waited on while stopping a service, it is identified
+by its start time so that a reused pid is not mistaken for it
+*/
+type trackedProcess struct {
+	Name  string
+	PID   int
+	Start uint64
+}
+
+func (p trackedProcess) String() string {
+	return fmt.Sprintf("%v (pid %d)", p.Name, p.PID)
+}
+
+func joinProcesses(procs []trackedProcess) string {
+	names := make([]string, 0, len(procs))
+	for _, p := range procs {
+		names = append(names, p.String())
+	}
+	return strings.Join(names, ", ")
+}
+
+/*
+processAlive reports whether process pid is still running, start is ignored when 0
+*/
+func processAlive(pid int, start uint64) bool {
+	if err := syscall.Kill(pid, 0); err == syscall.ESRCH {
+		return false
+	}
+	if !hasProc() {
+		return true
+	}
+	status, err := procStat(pid)
+	if err != nil {
+		return !os.IsNotExist(err)
+	}
+	return status.State != "Z" && (start == 0 || status.Start == start)
+}
+
+/*
+taskProcesses returns the processes running task executables whatever their parent,
+downstream runs are started in their own session and reparented once their upstream
+run exits. Task processes cannot be identified without /proc
+*/
+func taskProcesses() ([]trackedProcess, error) {
+	if !hasProc() {
+		return nil, nil
+	}
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return nil, err
+	}
+	//tasks are run through their executable or the _run.bin built next to it
+	executables := make(map[string]string, 2*len(tasks))
+	for _, task := range tasks {
+		executables[filepath.Base(task.Executable)] = task.Name
+		executables[strings.TrimSuffix(filepath.Base(task.Executable), ".bin")+"_run.bin"] = task.Name
+	}
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+	procs := make([]trackedProcess, 0)
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		args, err := procArgs(pid)
+		if err != nil || len(args) == 0 {
+			continue
+		}
+		name, ok := executables[filepath.Base(args[0])]
+		if !ok {
+			continue
+		}
+		status, err := procStat(pid)
+		if err != nil || status.State == "Z" {
+			continue
+		}
+		procs = append(procs, trackedProcess{Name: name, PID: pid, Start: status.Start})
+	}
+	return procs, nil
+}
+
+func aliveProcesses(procs []trackedProcess) []trackedProcess {
+	alive := make([]trackedProcess, 0, len(procs))
+	for _, p := range procs {
+		if processAlive(p.PID, p.Start) {
+			alive = append(alive, p)
+		}
+	}
+	return alive
+}
+
+/*
+waitProcesses waits up to timeout for procs to exit and returns the ones still running
+*/
+func waitProcesses(procs []trackedProcess, timeout time.Duration) []trackedProcess {
+	deadline := time.Now().Add(timeout)
+	for {
+		alive := aliveProcesses(procs)
+		if len(alive) == 0 || !time.Now().Before(deadline) {
+			return alive
+		}
+		time.Sleep(stopPollInterval)
+	}
+}
+
+func signalProcesses(procs []trackedProcess, sig syscall.Signal) {
+	for _, p := range procs {
+		if err := syscall.Kill(p.PID, sig); err != nil && err != syscall.ESRCH {
+			logError(fmt.Sprintf("failed to send %v to %v: %v", sig, p, err))
+		}
+	}
+}
+
+/*
+recordKilledRun removes the run file of a task process killed with SIGKILL and
+records its run as interrupted, a killed run cannot record itself
+*/
+func recordKilledRun(p trackedProcess) {
+	start, admitted, err := runner.RemoveRun(p.Name, p.PID)
+	if err != nil {
+		logError(fmt.Sprintf("failed to remove the run file of %v: %v", p, err))
+	}
+	if !admitted {
+		return
+	}
+	if err := runner.RecordKilled(cmdRepo, p.Name, start, "killed by keiji system stop --force"); err != nil {
+		logError(fmt.Sprintf("failed to record the run of %v: %v", p, err))
+	}
+}
+
+/*
+clearRunningFlags unsets IsRunning on tasks that have no running process left,
+the scheduler cannot clear the flags of the runs it was stopped during
+*/
+func clearRunningFlags() error {
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return err
+	}
+	procs, err := taskProcesses()
+	if err != nil {
+		return err
+	}
+	running := make(map[string]bool, len(procs))
+	for _, p := range procs {
+		running[p.Name] = true
+	}
+	cleared := make([]string, 0)
+	for _, task := range tasks {
+		if !task.IsRunning || running[task.Name] {
+			continue
+		}
+		if instances, err := runner.RunInstances(task.Name); err != nil || len(instances) > 0 {
+			continue
+		}
+		if _, err := cmdRepo.SetIsRunning(task.Name, false); err != nil {
+			return err
+		}
+		cleared = append(cleared, task.Name)
+	}
+	if len(cleared) > 0 {
+		logWarn(fmt.Sprintf("cleared the running flag of %v", strings.Join(cleared, ", ")))
+	}
+	return nil
+}
+
+/*
+stopServiceWith interrupts service and waits up to opts.Timeout for it and the tasks
+it started to exit. With opts.Force the processes still running are sent SIGTERM,
+then SIGKILL after stopEscalationDelay. Services managed by systemd units are
+stopped by systemctl, which applies the unit's own stop timeout
+*/
+func stopServiceWith(service c.Service, opts StopOptions) error {
+	if managed, err := unitAction("stop", service); managed {
+		if err == nil && service == c.SCHEDULER {
+			return clearRunningFlags()
+		}
+		return err
+	}
+	unlock, err := lockPIDFile(service)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	//stop service using its pid
+	pidPath := paths.PID_PATH(service)
+	process, err := removeStalePIDFile(service)
+	if err != nil {
+		return fmt.Errorf("error reading service PID: %v", err)
+	}
+	if process == nil || valid(process.Stale) {
+		logWarn(fmt.Sprintf("%s is not running", service))
+		return nil
+	}
+	//tasks are only run by the scheduler
+	inflight := []trackedProcess{}
+	if service == c.SCHEDULER {
+		if inflight, err = taskProcesses(); err != nil {
+			logError(err)
+		}
+	}
+	err = syscall.Kill(process.PID, syscall.SIGINT)
+	if err == syscall.EPERM {
+		return cmdErrors.ErrPermissionDenied("permission denied stopping %s (pid %d)", service, process.PID)
+	}
+	if err != nil {
+		return fmt.Errorf("kill error: %v", err)
+	}
+	if len(inflight) > 0 {
+		logInfo(fmt.Sprintf("waiting up to %v for running tasks to finish: %v", opts.Timeout, joinProcesses(inflight)))
+	}
+	targets := append([]trackedProcess{{Name: string(service), PID: process.PID, Start: process.Start}}, inflight...)
+	alive := waitProcesses(targets, opts.Timeout)
+	if len(alive) > 0 && !opts.Force {
+		return fmt.Errorf("%v did not stop within %v, still running: %v. Wait longer with --timeout or kill them with --force",
+			service, opts.Timeout, joinProcesses(alive))
+	}
+	if len(alive) > 0 {
+		logWarn(fmt.Sprintf("still running after %v, sending SIGTERM: %v", opts.Timeout, joinProcesses(alive)))
+		signalProcesses(alive, syscall.SIGTERM)
+		alive = waitProcesses(alive, stopEscalationDelay)
+	}
+	if len(alive) > 0 {
+		//the supervisor does not restart a killed service once its pid file is removed
+		os.Remove(pidPath)
+		logWarn(fmt.Sprintf("still running after SIGTERM, sending SIGKILL: %v", joinProcesses(alive)))
+		signalProcesses(alive, syscall.SIGKILL)
+		killed := alive
+		if alive = waitProcesses(alive, stopEscalationDelay); len(alive) > 0 {
+			return fmt.Errorf("failed to kill %v, run ps aux to inspect", joinProcesses(alive))
+		}
+		for _, p := range killed {
+			if p.PID != process.PID {
+				recordKilledRun(p)
+			}
+		}
+	}
+	if err := os.Remove(pidPath); err != nil && !os.IsNotExist(err) {
+		logError(err)
+	}
+	logInfo(fmt.Sprintf("%s stopped successfully", service))
+	if service == c.SCHEDULER {
+		return clearRunningFlags()
+	}
+	return nil
+}
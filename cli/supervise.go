@@ -0,0 +1,570 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/logging"
+	"github.com/aodr3w/keiji-core/paths"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/spf13/cobra"
+)
+
+// backoff between the restarts of a crashed service, it doubles after every crash
+const (
+	supervisorMinBackoff = time.Second
+	supervisorMaxBackoff = time.Minute
+)
+
+// supervisorStableAfter resets the backoff of a service that stayed up this long before crashing
+const supervisorStableAfter = time.Minute
+
+// supervisorStartGrace is how long a service must stay up before the services requiring it are started
+const supervisorStartGrace = 2 * time.Second
+
+// supervisorTick is how often the supervisor checks for services due to be started
+const supervisorTick = 500 * time.Millisecond
+
+var (
+	// SUPERVISOR_PID_PATH holds the pid of the running `keiji system supervise` process
+	SUPERVISOR_PID_PATH = filepath.Join(paths.SERVICE_EXECUTABLE, "supervisor.pid")
+	// SUPERVISOR_STATE_PATH records the crashes of supervised services, it is kept once the supervisor exits
+	SUPERVISOR_STATE_PATH = filepath.Join(paths.SYSTEM_ROOT, "supervisor.json")
+)
+
+/*
+SupervisedService is the state the supervisor records for a service
+*/
+type SupervisedService struct {
+	PID        int        `json:"pid,omitempty"`
+	Crashes    int        `json:"crashes"`
+	LastExit   string     `json:"lastExit,omitempty"`
+	LastExitAt *time.Time `json:"lastExitAt,omitempty"`
+	//StartRequested is set by `keiji system start` before it signals the supervisor
+	StartRequested bool `json:"startRequested,omitempty"`
+}
+
+/*
+SupervisorState is written to SUPERVISOR_STATE_PATH whenever a supervised service starts or exits
+*/
+type SupervisorState struct {
+	PID      int                              `json:"pid,omitempty"`
+	Since    time.Time                        `json:"since"`
+	Services map[c.Service]*SupervisedService `json:"services"`
+}
+
+/*
+readSupervisorState returns the state recorded by the last supervisor, nil if none ever ran
+*/
+func readSupervisorState() (*SupervisorState, error) {
+	content, err := os.ReadFile(SUPERVISOR_STATE_PATH)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	state := &SupervisorState{}
+	if err := json.Unmarshal(content, state); err != nil {
+		return nil, fmt.Errorf("invalid supervisor state %v: %v", SUPERVISOR_STATE_PATH, err)
+	}
+	return state, nil
+}
+
+/*
+lockSupervisorState serializes the updates of SUPERVISOR_STATE_PATH by the
+supervisor and `keiji system start`, the returned function releases the lock
+*/
+func lockSupervisorState() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(SUPERVISOR_STATE_PATH), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(SUPERVISOR_STATE_PATH+".lock", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+/*
+requestStart records in the supervisor state that service is to be started
+again, the supervisor reads the request when it receives SIGHUP
+*/
+func requestStart(service c.Service) error {
+	unlock, err := lockSupervisorState()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	state, err := readSupervisorState()
+	if err != nil {
+		return err
+	}
+	if state == nil || state.Services[service] == nil {
+		return fmt.Errorf("supervisor state %v does not record %v", SUPERVISOR_STATE_PATH, service)
+	}
+	state.Services[service].StartRequested = true
+	return writeSupervisorState(state)
+}
+
+func writeSupervisorState(state *SupervisorState) error {
+	content, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := SUPERVISOR_STATE_PATH + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, SUPERVISOR_STATE_PATH)
+}
+
+/*
+supervisorPID returns the pid of the running supervisor, 0 when no supervisor is running.
+The supervisor holds a lock on its pid file until it exits, a pid file that is not locked is stale
+*/
+func supervisorPID() int {
+	f, err := os.Open(SUPERVISOR_PID_PATH)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err == nil {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		return 0
+	}
+	pid, err := readPID(SUPERVISOR_PID_PATH)
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+/*
+lockSupervisor writes the pid of the supervisor and locks its pid file, it fails
+when another supervisor holds the lock. The returned function releases the lock
+*/
+func lockSupervisor() (func(), error) {
+	if err := os.MkdirAll(filepath.Dir(SUPERVISOR_PID_PATH), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(SUPERVISOR_PID_PATH, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			pid, _ := readPID(SUPERVISOR_PID_PATH)
+			return nil, fmt.Errorf("services are already supervised by pid %d", pid)
+		}
+		return nil, err
+	}
+	if err := f.Truncate(0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.WriteString(fmt.Sprintf("%d\n", os.Getpid())); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		os.Remove(SUPERVISOR_PID_PATH)
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+/*
+supervisorStart asks the running supervisor to start service again after it was stopped.
+It returns false when no supervisor is running
+*/
+func supervisorStart(service c.Service) (bool, error) {
+	pid := supervisorPID()
+	if pid == 0 {
+		return false, nil
+	}
+	if isServiceRunning(service) {
+		logWarn("service already running")
+		return true, nil
+	}
+	if err := requestStart(service); err != nil {
+		return true, err
+	}
+	if err := syscall.Kill(pid, syscall.SIGHUP); err != nil {
+		return true, fmt.Errorf("failed to signal supervisor (pid %d): %v", pid, err)
+	}
+	for c := 0; c < maxRetries; c++ {
+		time.Sleep(retryInterval)
+		if isServiceRunning(service) {
+			logInfo(fmt.Sprintf("%v started by supervisor (pid %d)", service, pid))
+			return true, nil
+		}
+	}
+	return true, fmt.Errorf("supervisor did not start %v, see its output", service)
+}
+
+/*
+stopSupervisor interrupts the running supervisor, which stops the services it supervises
+*/
+func stopSupervisor() error {
+	pid := supervisorPID()
+	if pid == 0 {
+		return nil
+	}
+	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
+		return fmt.Errorf("failed to stop supervisor (pid %d): %v", pid, err)
+	}
+	//the supervisor waits for every service to stop before exiting
+	retries := maxRetries * (len(c.SERVICES) + 1)
+	for r := 0; r < retries; r++ {
+		if supervisorPID() == 0 {
+			logInfo("supervisor stopped successfully")
+			return nil
+		}
+		time.Sleep(retryInterval)
+	}
+	return fmt.Errorf("failed to stop supervisor (pid %d) after %d retries", pid, retries)
+}
+
+/*
+describeExit returns the exit reason of a process and whether it exited on request,
+a clean exit or termination by SIGINT or SIGTERM e.g `keiji system stop` is not a crash
+*/
+func describeExit(ps *os.ProcessState) (string, bool) {
+	if ps == nil {
+		return "unknown", false
+	}
+	if status, ok := ps.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		sig := status.Signal()
+		return ps.String(), sig == syscall.SIGINT || sig == syscall.SIGTERM
+	}
+	return ps.String(), ps.ExitCode() == 0
+}
+
+type supervisedProc struct {
+	cmd     *exec.Cmd
+	started time.Time
+	backoff time.Duration
+	//restartAt delays the next start of a crashed service
+	restartAt time.Time
+	//stopped services exited on request, they are started again by `keiji system start`
+	stopped bool
+}
+
+type serviceExit struct {
+	service c.Service
+	cmd     *exec.Cmd
+}
+
+/*
+supervisor runs every service as a child process and restarts the ones that crash
+*/
+type supervisor struct {
+	state *SupervisorState
+	procs map[c.Service]*supervisedProc
+	exits chan serviceExit
+}
+
+func newSupervisor() *supervisor {
+	s := &supervisor{
+		state: &SupervisorState{
+			PID:      os.Getpid(),
+			Since:    time.Now(),
+			Services: make(map[c.Service]*SupervisedService, len(c.SERVICES)),
+		},
+		procs: make(map[c.Service]*supervisedProc, len(c.SERVICES)),
+		exits: make(chan serviceExit),
+	}
+	for _, service := range c.SERVICES {
+		s.state.Services[service] = &SupervisedService{}
+		s.procs[service] = &supervisedProc{}
+	}
+	return s
+}
+
+/*
+saveState writes the state of the supervisor, keeping the start requests
+recorded since the last SIGHUP
+*/
+func (s *supervisor) saveState() {
+	unlock, err := lockSupervisorState()
+	if err != nil {
+		logError(fmt.Sprintf("failed to save supervisor state: %v", err))
+		return
+	}
+	defer unlock()
+	if saved, err := readSupervisorState(); err == nil && saved != nil && saved.PID == s.state.PID {
+		for service, state := range s.state.Services {
+			if requested := saved.Services[service]; requested != nil {
+				state.StartRequested = requested.StartRequested
+			}
+		}
+	}
+	if err := writeSupervisorState(s.state); err != nil {
+		logError(fmt.Sprintf("failed to save supervisor state: %v", err))
+	}
+}
+
+/*
+startRequested hands back the services `keiji system start` asked to start
+again, their requests are cleared
+*/
+func (s *supervisor) startRequested() {
+	unlock, err := lockSupervisorState()
+	if err != nil {
+		logError(fmt.Sprintf("failed to read start requests: %v", err))
+		return
+	}
+	defer unlock()
+	saved, err := readSupervisorState()
+	if err != nil || saved == nil {
+		logError(fmt.Sprintf("failed to read start requests: %v", err))
+		return
+	}
+	for service, state := range s.state.Services {
+		if requested := saved.Services[service]; requested != nil && requested.StartRequested {
+			s.procs[service].stopped = false
+			logInfo(fmt.Sprintf("%v start requested", service))
+		}
+		state.StartRequested = false
+	}
+	if err := writeSupervisorState(s.state); err != nil {
+		logError(fmt.Sprintf("failed to save supervisor state: %v", err))
+	}
+}
+
+/*
+launch starts service with its output appended to its log file and writes its pid file
+*/
+func (s *supervisor) launch(service c.Service) error {
+	executable, err := getServicePath(service)
+	if err != nil {
+		return err
+	}
+	logsPath, err := getServiceLogPath(service)
+	if err != nil {
+		return err
+	}
+	if _, err := logging.NewFileLogger(logsPath); err != nil {
+		return err
+	}
+	out, err := os.OpenFile(logsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.Command(executable)
+	cmd.Dir = paths.WORKSPACE
+	cmd.Stdout, cmd.Stderr = out, out
+	//services are stopped in order by the supervisor, not by the terminal's interrupt
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := writePIDFile(paths.PID_PATH(service), cmd.Process.Pid); err != nil {
+		logError(err)
+	}
+	proc := s.procs[service]
+	proc.cmd, proc.started = cmd, time.Now()
+	s.state.Services[service].PID = cmd.Process.Pid
+	s.saveState()
+	logInfo(fmt.Sprintf("%v started with pid %d", service, cmd.Process.Pid))
+	go func() {
+		cmd.Wait()
+		s.exits <- serviceExit{service: service, cmd: cmd}
+	}()
+	return nil
+}
+
+/*
+ready reports whether every dependency of service has been up for supervisorStartGrace
+*/
+func (s *supervisor) ready(service c.Service, now time.Time) bool {
+	for _, dependency := range unitDependencies[service] {
+		proc := s.procs[dependency]
+		if proc.cmd == nil || now.Sub(proc.started) < supervisorStartGrace {
+			return false
+		}
+	}
+	return true
+}
+
+/*
+startDue starts, in the order of c.SERVICES, the services that are not running
+and whose backoff elapsed
+*/
+func (s *supervisor) startDue(now time.Time) {
+	for _, service := range c.SERVICES {
+		proc := s.procs[service]
+		if proc.cmd != nil || proc.stopped || now.Before(proc.restartAt) || !s.ready(service, now) {
+			continue
+		}
+		if err := s.launch(service); err != nil {
+			logError(fmt.Sprintf("failed to start %v: %v", service, err))
+			s.crashed(service, err.Error(), 0, now)
+		}
+	}
+}
+
+/*
+crashed records an unexpected exit of service after it was up for upFor, 0 when
+it failed to start, and schedules its restart
+*/
+func (s *supervisor) crashed(service c.Service, reason string, upFor time.Duration, now time.Time) {
+	proc, state := s.procs[service], s.state.Services[service]
+	state.Crashes++
+	switch {
+	case proc.backoff == 0 || upFor >= supervisorStableAfter:
+		proc.backoff = supervisorMinBackoff
+	default:
+		proc.backoff = min(2*proc.backoff, supervisorMaxBackoff)
+	}
+	proc.restartAt = now.Add(proc.backoff)
+	logWarn(fmt.Sprintf("%v exited unexpectedly (%v), restarting in %v (crash %d)", service, reason, proc.backoff, state.Crashes))
+}
+
+/*
+exited records the exit of a supervised service, crashes are restarted
+*/
+func (s *supervisor) exited(exit serviceExit, restart bool) {
+	now := time.Now()
+	proc, state := s.procs[exit.service], s.state.Services[exit.service]
+	reason, expected := describeExit(exit.cmd.ProcessState)
+	//`keiji system stop --force` removes the pid file of a service before killing it
+	if pid, err := readPID(paths.PID_PATH(exit.service)); err != nil || pid != exit.cmd.Process.Pid {
+		expected = true
+	}
+	proc.cmd = nil
+	if err := os.Remove(paths.PID_PATH(exit.service)); err != nil && !os.IsNotExist(err) {
+		logError(err)
+	}
+	state.PID, state.LastExit, state.LastExitAt = 0, reason, &now
+	switch {
+	case !restart:
+		logInfo(fmt.Sprintf("%v stopped (%v)", exit.service, reason))
+	case expected:
+		proc.stopped = true
+		logInfo(fmt.Sprintf("%v stopped (%v), start it again with `keiji system start %v`", exit.service, reason, exit.service))
+	default:
+		s.crashed(exit.service, reason, now.Sub(proc.started), now)
+	}
+	s.saveState()
+}
+
+/*
+shutdown stops the services in the reverse order of c.SERVICES, services that
+ignore SIGINT are killed after maxRetries*retryInterval
+*/
+func (s *supervisor) shutdown() {
+	for i := len(c.SERVICES) - 1; i >= 0; i-- {
+		service := c.SERVICES[i]
+		proc := s.procs[service]
+		if proc.cmd == nil {
+			continue
+		}
+		proc.cmd.Process.Signal(syscall.SIGINT)
+		timeout := time.After(maxRetries * retryInterval)
+		for proc.cmd != nil {
+			select {
+			case exit := <-s.exits:
+				s.exited(exit, false)
+			case <-timeout:
+				logWarn(fmt.Sprintf("%v did not stop in %v, killing it", service, maxRetries*retryInterval))
+				proc.cmd.Process.Kill()
+			}
+		}
+	}
+	s.state.PID = 0
+	s.saveState()
+}
+
+/*
+supervise runs the services in the foreground until interrupted. SIGHUP starts
+the stopped services whose start was requested in the state file, SIGINT &
+SIGTERM stop every service then exit
+*/
+func supervise() error {
+	if scope := installedUnits(); valid(scope) {
+		return fmt.Errorf("services are managed by %v systemd units, remove them with `keiji system remove-units` to supervise them", scope)
+	}
+	for _, service := range c.SERVICES {
+		installed, err := isServiceInstalled(service)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			return cmdErrors.ErrServiceNotInstalled(service)
+		}
+	}
+	unlock, err := lockSupervisor()
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	//services started through pid files would be started twice
+	for _, service := range c.SERVICES {
+		if isServiceRunning(service) {
+			if err := stopService(service); err != nil {
+				return err
+			}
+		}
+	}
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+	s := newSupervisor()
+	s.saveState()
+	logInfo(fmt.Sprintf("supervising %v with pid %d, press Ctrl-C to stop", serviceNames(), os.Getpid()))
+	ticker := time.NewTicker(supervisorTick)
+	defer ticker.Stop()
+	for {
+		s.startDue(time.Now())
+		select {
+		case exit := <-s.exits:
+			s.exited(exit, true)
+		case <-ticker.C:
+		case sig := <-signals:
+			if sig == syscall.SIGHUP {
+				s.startRequested()
+				continue
+			}
+			logInfo(fmt.Sprintf("received %v, stopping services", sig))
+			s.shutdown()
+			return nil
+		}
+	}
+}
+
+func newSystemSuperviseCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:   "supervise",
+		Short: "run and restart services in the foreground",
+		Long: "starts every service and restarts the ones that crash with an exponential backoff from " +
+			supervisorMinBackoff.String() + " to " + supervisorMaxBackoff.String() + ".\n" +
+			"The scheduler is started once the bus is up. Services stopped with `keiji system stop` are not restarted,\n" +
+			"`keiji system start` hands them back to the supervisor. Crashes are shown by `keiji system status -o wide`",
+		Example: "keiji system supervise\nnohup keiji system supervise > supervisor.log 2>&1 &",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := checkWorkSpace(); err != nil {
+				return err
+			}
+			return supervise()
+		},
+	}
+}
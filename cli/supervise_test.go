@@ -0,0 +1,112 @@
+package cli
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+)
+
+func TestSupervisorCrashBackoff(t *testing.T) {
+	tests := []struct {
+		name   string
+		upFor  []time.Duration
+		wantIn []time.Duration
+	}{
+		{
+			name:   "repeated launch failures",
+			upFor:  []time.Duration{0, 0, 0, 0},
+			wantIn: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second},
+		},
+		{
+			name:   "quick crashes",
+			upFor:  []time.Duration{time.Second, 3 * time.Second, 0},
+			wantIn: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+		{
+			name:   "stable run resets the backoff",
+			upFor:  []time.Duration{0, 0, 0, supervisorStableAfter, 0},
+			wantIn: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, time.Second, 2 * time.Second},
+		},
+		{
+			name:  "capped",
+			upFor: []time.Duration{0, 0, 0, 0, 0, 0, 0, 0},
+			wantIn: []time.Duration{
+				time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second,
+				16 * time.Second, 32 * time.Second, supervisorMaxBackoff, supervisorMaxBackoff,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSupervisor()
+			now := time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC)
+			for i, upFor := range tt.upFor {
+				want := tt.wantIn[i]
+				s.crashed(c.SCHEDULER, "exit status 1", upFor, now)
+				if got := s.procs[c.SCHEDULER].restartAt.Sub(now); got != want {
+					t.Errorf("crash %d restarts in %v, want %v", i+1, got, want)
+				}
+				now = s.procs[c.SCHEDULER].restartAt
+			}
+			if crashes := s.state.Services[c.SCHEDULER].Crashes; crashes != len(tt.upFor) {
+				t.Errorf("recorded %d crashes, want %d", crashes, len(tt.upFor))
+			}
+		})
+	}
+}
+
+func TestSupervisorStartRequested(t *testing.T) {
+	defer func(path string) { SUPERVISOR_STATE_PATH = path }(SUPERVISOR_STATE_PATH)
+	tests := []struct {
+		name      string
+		requested []c.Service
+	}{
+		{name: "scheduler", requested: []c.Service{c.SCHEDULER}},
+		{name: "bus", requested: []c.Service{c.TCP_BUS}},
+		{name: "every service", requested: []c.Service{c.TCP_BUS, c.SCHEDULER}},
+		{name: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SUPERVISOR_STATE_PATH = filepath.Join(t.TempDir(), "supervisor.json")
+			s := newSupervisor()
+			for _, proc := range s.procs {
+				proc.stopped = true
+			}
+			s.saveState()
+			for _, service := range tt.requested {
+				if err := requestStart(service); err != nil {
+					t.Fatal(err)
+				}
+			}
+			//a save between the request and the signal keeps the request
+			s.saveState()
+			s.startRequested()
+			for _, service := range c.SERVICES {
+				if want := !containsService(tt.requested, service); s.procs[service].stopped != want {
+					t.Errorf("%v stopped = %v, want %v", service, s.procs[service].stopped, want)
+				}
+			}
+			state, err := readSupervisorState()
+			if err != nil {
+				t.Fatal(err)
+			}
+			for service, recorded := range state.Services {
+				if recorded.StartRequested {
+					t.Errorf("start request of %v was not cleared", service)
+				}
+			}
+		})
+	}
+}
+
+func containsService(services []c.Service, service c.Service) bool {
+	for _, s := range services {
+		if s == service {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,297 @@
+package cli
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/spf13/cobra"
+)
+
+// tagsEnvKey is the key of the task's .env file holding its comma separated tags
+const tagsEnvKey = "TASK_TAGS"
+
+var tagPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+
+/*
+parseTags validates tags and removes duplicates
+*/
+func parseTags(tags []string) ([]string, error) {
+	parsed := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		tag = strings.TrimSpace(tag)
+		if !tagPattern.MatchString(tag) {
+			return nil, fmt.Errorf("invalid tag %q, tags may only contain letters, digits, - and _", tag)
+		}
+		if !containsFold(parsed, tag) {
+			parsed = append(parsed, tag)
+		}
+	}
+	sort.Strings(parsed)
+	return parsed, nil
+}
+
+/*
+taskTags returns the tags declared in the task's .env file
+*/
+func taskTags(name string) ([]string, error) {
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return nil, err
+	}
+	if !valid(env[tagsEnvKey]) {
+		return []string{}, nil
+	}
+	return parseTags(strings.Split(env[tagsEnvKey], ","))
+}
+
+/*
+editTags adds and removes tags of the task named name in its .env file
+*/
+func editTags(name string, add []string, remove []string) error {
+	exists, err := taskSourceExists(name)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		_, err := getTaskByName(name)
+		if err == nil {
+			err = fmt.Errorf("source of task %v not found in %v", name, paths.TASKS_PATH)
+		}
+		return err
+	}
+	tags, err := taskTags(name)
+	if err != nil {
+		return err
+	}
+	if tags, err = parseTags(append(tags, add...)); err != nil {
+		return err
+	}
+	kept := make([]string, 0, len(tags))
+	for _, tag := range tags {
+		if !containsFold(remove, tag) {
+			kept = append(kept, tag)
+		}
+	}
+	env, err := readTaskEnv(name)
+	if err != nil {
+		return err
+	}
+	env[tagsEnvKey] = strings.Join(kept, ",")
+	if len(kept) == 0 {
+		delete(env, tagsEnvKey)
+	}
+	if err := writeTaskEnv(name, env); err != nil {
+		return err
+	}
+	if len(kept) == 0 {
+		logInfo(fmt.Sprintf("%v has no tags", name))
+		return nil
+	}
+	logInfo(fmt.Sprintf("%v tags: %v", name, strings.Join(kept, ", ")))
+	return nil
+}
+
+func taskSourceExists(name string) (bool, error) {
+	info, err := os.Stat(taskEnvPath(name))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil && !info.IsDir(), err
+}
+
+/*
+TaskSelector selects the tasks a command operates on, either a single task by name,
+the tasks carrying any of Tags or All tasks
+*/
+type TaskSelector struct {
+	Name string
+	Tags []string
+	All  bool
+	//Yes skips the confirmation prompt of bulk operations
+	Yes bool
+	//Unbuilt also selects tasks of TASKS_PATH that have no database record yet
+	Unbuilt bool
+}
+
+func (s *TaskSelector) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVar(&s.Name, "name", "", "name of the task")
+	cmd.Flags().StringSliceVar(&s.Tags, "tag", nil, "operate on every task carrying one of these tags")
+	cmd.Flags().BoolVar(&s.All, "all", false, "operate on every task")
+	cmd.Flags().BoolVarP(&s.Yes, "yes", "y", false, "do not ask for confirmation of bulk operations")
+}
+
+func (s *TaskSelector) bulk() bool {
+	return s.All || len(s.Tags) > 0
+}
+
+/*
+args validates that exactly one of a task name, --tag or --all is provided
+*/
+func (s *TaskSelector) args(cmd *cobra.Command, args []string) error {
+	if !s.bulk() {
+		return taskNameArgs(&s.Name)(cmd, args)
+	}
+	if len(args) > 0 || valid(s.Name) {
+		return fmt.Errorf("a task name cannot be combined with --tag or --all")
+	}
+	if s.All && len(s.Tags) > 0 {
+		return fmt.Errorf("--tag and --all cannot be combined")
+	}
+	_, err := parseTags(s.Tags)
+	return err
+}
+
+/*
+selectTasks returns the names of the selected tasks sorted by name
+*/
+func (s *TaskSelector) selectTasks() ([]string, error) {
+	if !s.bulk() {
+		return []string{s.Name}, nil
+	}
+	names := make([]string, 0)
+	tasks, err := cmdRepo.GetAllTasks()
+	if err != nil {
+		return nil, err
+	}
+	for _, task := range tasks {
+		names = append(names, task.Name)
+	}
+	if s.Unbuilt {
+		entries, err := os.ReadDir(paths.TASKS_PATH)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() && !containsFold(names, entry.Name()) {
+				names = append(names, entry.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	if s.All {
+		return names, nil
+	}
+	selected := make([]string, 0)
+	for _, name := range names {
+		tags, err := taskTags(name)
+		if err != nil {
+			return nil, err
+		}
+		for _, tag := range s.Tags {
+			if containsFold(tags, tag) {
+				selected = append(selected, name)
+				break
+			}
+		}
+	}
+	return selected, nil
+}
+
+/*
+confirm asks the user to confirm a bulk operation on stdin
+*/
+func confirm(action string, names []string) error {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return fmt.Errorf("%v of %d tasks requires confirmation, provide --yes when not running interactively", action, len(names))
+	}
+	fmt.Fprintf(os.Stderr, "%v %d tasks: %v\nproceed? [y/N] ", action, len(names), strings.Join(names, ", "))
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	if answer != "y" && answer != "yes" {
+		return fmt.Errorf("%v cancelled", action)
+	}
+	return nil
+}
+
+/*
+TaskResult is the outcome of a bulk operation on a single task
+*/
+type TaskResult struct {
+	Task   string `json:"task" yaml:"task"`
+	Action string `json:"action" yaml:"action"`
+	Result string `json:"result" yaml:"result"`
+	Error  string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+/*
+bulkAction applies fn to the selected tasks. A single task behaves like the
+plain command, bulk operations are confirmed first and end with a summary table
+*/
+func bulkAction(action string, s *TaskSelector, fn func(name string) error) func() error {
+	return func() error {
+		if !s.bulk() {
+			return fn(s.Name)
+		}
+		names, err := s.selectTasks()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("no tasks match the selection")
+		}
+		if !s.Yes {
+			if err := confirm(action, names); err != nil {
+				return err
+			}
+		}
+		results := make([]TaskResult, 0, len(names))
+		failed := 0
+		for _, name := range names {
+			result := TaskResult{Task: name, Action: action, Result: "ok"}
+			if err := fn(name); err != nil {
+				result.Result = "failed"
+				result.Error = err.Error()
+				failed++
+			}
+			results = append(results, result)
+		}
+		err = printOutput("TaskResultList", results, func(w io.Writer, wide bool) {
+			fmt.Fprintln(w, "TASK\tACTION\tRESULT\tERROR")
+			for _, r := range results {
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", r.Task, r.Action, r.Result, r.Error)
+			}
+		})
+		if err != nil {
+			return err
+		}
+		if failed > 0 {
+			return fmt.Errorf("%v failed for %d of %d tasks", action, failed, len(names))
+		}
+		return nil
+	}
+}
+
+func newTaskTagCMD() *cobra.Command {
+	var name string
+	var add, remove []string
+	cmd := &cobra.Command{
+		Use:   "tag NAME",
+		Short: "edit the tags of a task",
+		Long: "adds or removes tags of a task, tags select tasks in bulk operations e.g `keiji task disable --tag=billing`.\n" +
+			"The tags are printed when neither --add nor --remove is provided",
+		Example: "keiji task tag invoices --add=billing,nightly\nkeiji task tag invoices --remove=nightly",
+		Args:    taskNameArgs(&name),
+		RunE: taskAction(func() error {
+			if len(add) == 0 && len(remove) == 0 {
+				tags, err := taskTags(name)
+				if err != nil {
+					return err
+				}
+				fmt.Println(strings.Join(tags, "\n"))
+				return nil
+			}
+			return editTags(name, add, remove)
+		}),
+	}
+	cmd.Flags().StringVar(&name, "name", "", "name of the task")
+	cmd.Flags().StringSliceVar(&add, "add", nil, "tags to add")
+	cmd.Flags().StringSliceVar(&remove, "remove", nil, "tags to remove")
+	return cmd
+}
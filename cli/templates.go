@@ -0,0 +1,304 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/aodr3w/keiji-core/utils"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultTemplate is the task template shipped with keiji-core
+const DefaultTemplate = "default"
+
+// templateSuffix is stripped from the name of template files once rendered
+const templateSuffix = ".tmpl"
+
+// TEMPLATES_PATH registers custom task templates, mapping their names onto their directories
+var TEMPLATES_PATH = filepath.Join(paths.SYSTEM_ROOT, "templates.yaml")
+
+// templateFiles must be provided by every task template, `keiji task build` runs them
+var templateFiles = []string{"main.go", "schedule.go", "function.go"}
+
+/*
+TemplateData holds the variables available to task templates e.g {{.Name}}
+*/
+type TemplateData struct {
+	Name        string
+	Description string
+}
+
+/*
+TemplateView is the serialized representation of a task template
+*/
+type TemplateView struct {
+	Name string `json:"name" yaml:"name"`
+	Path string `json:"path" yaml:"path"`
+}
+
+/*
+readTemplates returns the registered templates keyed by name
+*/
+func readTemplates() (map[string]string, error) {
+	templates := make(map[string]string)
+	content, err := os.ReadFile(TEMPLATES_PATH)
+	if os.IsNotExist(err) {
+		return templates, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := yaml.Unmarshal(content, &templates); err != nil {
+		return nil, fmt.Errorf("invalid template registry %v: %v", TEMPLATES_PATH, err)
+	}
+	return templates, nil
+}
+
+func writeTemplates(templates map[string]string) error {
+	content, err := yaml.Marshal(templates)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(TEMPLATES_PATH, content, 0644)
+}
+
+/*
+templateFileName returns the name of the file rendered from a template file
+*/
+func templateFileName(name string) string {
+	return strings.TrimSuffix(name, templateSuffix)
+}
+
+/*
+checkTemplate confirms that dir holds the files of a task and that every file parses as a template
+*/
+func checkTemplate(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("template %v is not a directory", dir)
+	}
+	found := make([]string, 0, len(templateFiles))
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dir, path)
+		found = append(found, templateFileName(rel))
+		if _, err := template.New(rel).Parse(string(content)); err != nil {
+			return fmt.Errorf("invalid template file %v: %v", rel, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, file := range templateFiles {
+		if !containsFold(found, file) {
+			return fmt.Errorf("template %v is missing %v, task templates must provide %v", dir, file, strings.Join(templateFiles, ", "))
+		}
+	}
+	return nil
+}
+
+/*
+templatePath returns the directory of the template named name,
+the default template is located in the keiji-core module
+*/
+func templatePath(name string) (string, error) {
+	if !valid(name) || name == DefaultTemplate {
+		repoPath, err := getTemplateRepoPath(false)
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(repoPath, "templates", "tasks"), nil
+	}
+	templates, err := readTemplates()
+	if err != nil {
+		return "", err
+	}
+	dir, ok := templates[name]
+	if !ok {
+		return "", fmt.Errorf("template %v not found, see `keiji template list`", name)
+	}
+	return dir, nil
+}
+
+/*
+renderTemplate copies the template named name into taskPath, rendering every file
+with data. Files of the default template are copied as is
+*/
+func renderTemplate(name string, taskPath string, data TemplateData) error {
+	dir, err := templatePath(name)
+	if err != nil {
+		return err
+	}
+	if !valid(name) || name == DefaultTemplate {
+		return utils.CopyDir(dir, taskPath, 0644)
+	}
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(taskPath, templateFileName(rel))
+		if d.IsDir() {
+			return os.MkdirAll(target, 0755)
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tmpl, err := template.New(rel).Option("missingkey=error").Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("invalid template file %v: %v", rel, err)
+		}
+		f, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := tmpl.Execute(f, data); err != nil {
+			return fmt.Errorf("failed to render template file %v: %v", rel, err)
+		}
+		return nil
+	})
+}
+
+func addTemplate(name string, dir string) error {
+	if !taskNamePattern.MatchString(name) || name == DefaultTemplate {
+		return fmt.Errorf("invalid template name %q, names may only contain letters, digits, - and _ and cannot be %v", name, DefaultTemplate)
+	}
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	if err := checkTemplate(dir); err != nil {
+		return err
+	}
+	templates, err := readTemplates()
+	if err != nil {
+		return err
+	}
+	if existing, ok := templates[name]; ok && existing != dir {
+		logWarn(fmt.Sprintf("template %v pointed to %v", name, existing))
+	}
+	templates[name] = dir
+	if err := writeTemplates(templates); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("template %v added, use it with `keiji task create NAME --template=%v`", name, name))
+	return nil
+}
+
+func removeTemplate(name string) error {
+	templates, err := readTemplates()
+	if err != nil {
+		return err
+	}
+	if _, ok := templates[name]; !ok {
+		return fmt.Errorf("template %v not found, see `keiji template list`", name)
+	}
+	delete(templates, name)
+	if err := writeTemplates(templates); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("template %v removed, its directory was left in place", name))
+	return nil
+}
+
+func listTemplates() error {
+	templates, err := readTemplates()
+	if err != nil {
+		return err
+	}
+	views := make([]TemplateView, 0, len(templates)+1)
+	if dir, err := templatePath(DefaultTemplate); err == nil {
+		views = append(views, TemplateView{Name: DefaultTemplate, Path: dir})
+	}
+	names := make([]string, 0, len(templates))
+	for name := range templates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		views = append(views, TemplateView{Name: name, Path: templates[name]})
+	}
+	return printOutput("TemplateList", views, func(w io.Writer, wide bool) {
+		fmt.Fprintln(w, "NAME\tPATH")
+		for _, v := range views {
+			fmt.Fprintf(w, "%s\t%s\n", v.Name, v.Path)
+		}
+	})
+}
+
+func NewTemplateCMD() *cobra.Command {
+	templateCMD := &cobra.Command{
+		Use:   "template",
+		Short: "manage task templates",
+		Long: "commands to register directories used as templates by `keiji task create --template`.\n" +
+			"Every file of a template is rendered with Go text/template, {{.Name}} and {{.Description}} hold the name\n" +
+			"and description of the new task. A " + templateSuffix + " suffix is removed from file names",
+	}
+	templateCMD.AddCommand(newTemplateAddCMD(), newTemplateListCMD(), newTemplateRemoveCMD())
+	return templateCMD
+}
+
+func newTemplateAddCMD() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "add NAME DIR",
+		Short:   "register a task template",
+		Long:    "registers DIR as the template NAME, it must provide " + strings.Join(templateFiles, ", "),
+		Example: "keiji template add http-check ./templates/http-check",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return taskAction(func() error {
+				return addTemplate(args[0], args[1])
+			})(cmd, args)
+		},
+	}
+	return cmd
+}
+
+func newTemplateListCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "list task templates",
+		Args:  cobra.NoArgs,
+		RunE: taskAction(func() error {
+			return listTemplates()
+		}),
+	}
+}
+
+func newTemplateRemoveCMD() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "remove NAME",
+		Short: "unregister a task template",
+		Long:  "removes the template NAME from the registry, its directory is left in place",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return taskAction(func() error {
+				return removeTemplate(args[0])
+			})(cmd, args)
+		},
+	}
+	return cmd
+}
@@ -0,0 +1,509 @@
+package cli
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/template"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+	"github.com/aodr3w/keiji-core/logging"
+	"github.com/aodr3w/keiji-core/paths"
+	cmdErrors "github.com/aodr3w/keiji/errors"
+	"github.com/spf13/cobra"
+)
+
+// systemd unit scopes, user units run in the user's service manager
+const (
+	UserUnits   = "user"
+	SystemUnits = "system"
+)
+
+// systemUnitsPath holds the units of the system service manager
+const systemUnitsPath = "/etc/systemd/system"
+
+// unitRestartSec is how long systemd waits before restarting a failed service
+const unitRestartSec = 5 * time.Second
+
+// unitDependencies lists the services a service requires, they are started before it
+var unitDependencies = map[c.Service][]c.Service{
+	c.SCHEDULER: {c.TCP_BUS},
+}
+
+var unitTemplate = template.Must(template.New("unit").Parse(`# generated by keiji system install-units, changes are overwritten on reinstall
+[Unit]
+Description=keiji {{.Service}}
+{{- if .After}}
+After={{range $i, $a := .After}}{{if $i}} {{end}}{{$a}}{{end}}
+{{- end}}
+{{- if .Wants}}
+Wants={{range $i, $w := .Wants}}{{if $i}} {{end}}{{$w}}{{end}}
+{{- end}}
+{{- if .Requires}}
+Requires={{range $i, $r := .Requires}}{{if $i}} {{end}}{{$r}}{{end}}
+{{- end}}
+
+[Service]
+Type=simple
+{{- if .User}}
+User={{.User}}
+{{- end}}
+Environment=HOME={{.Home}}
+WorkingDirectory={{.Workspace}}
+ExecStart={{.Executable}}
+ExecStartPost=/bin/sh -c 'echo $MAINPID > {{.PIDPath}}'
+ExecStopPost=/bin/rm -f {{.PIDPath}}
+KillSignal=SIGINT
+Restart=on-failure
+RestartSec={{.RestartSec}}
+StandardOutput=append:{{.LogPath}}
+StandardError=append:{{.LogPath}}
+
+[Install]
+WantedBy={{.WantedBy}}
+`))
+
+/*
+UnitData holds the values rendered into the unit file of a service
+*/
+type UnitData struct {
+	Service c.Service
+	//After & Wants hold network-online.target for system units only, the user service manager cannot order on it
+	After      []string
+	Wants      []string
+	Requires   []string
+	User       string
+	Home       string
+	Workspace  string
+	Executable string
+	PIDPath    string
+	LogPath    string
+	RestartSec int
+	WantedBy   string
+}
+
+func unitName(service c.Service) string {
+	return fmt.Sprintf("keiji-%v.service", service)
+}
+
+/*
+unitsPath returns the directory holding the units of scope
+*/
+func unitsPath(scope string) (string, error) {
+	if scope == SystemUnits {
+		return systemUnitsPath, nil
+	}
+	config, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(config, "systemd", "user"), nil
+}
+
+/*
+installedUnits returns the scope of the installed keiji units, empty when
+services are managed through pid files
+*/
+func installedUnits() string {
+	for _, scope := range []string{UserUnits, SystemUnits} {
+		dir, err := unitsPath(scope)
+		if err != nil {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, unitName(c.SCHEDULER))); err == nil {
+			return scope
+		}
+	}
+	return ""
+}
+
+/*
+systemctl runs systemctl for scope and returns its trimmed output
+*/
+func systemctl(scope string, args ...string) (string, error) {
+	if scope == UserUnits {
+		args = append([]string{"--user"}, args...)
+	}
+	output, err := exec.Command("systemctl", args...).CombinedOutput()
+	out := strings.TrimSpace(string(output))
+	if err != nil {
+		return out, fmt.Errorf("systemctl %v failed: %v %v", strings.Join(args, " "), err, out)
+	}
+	return out, nil
+}
+
+/*
+unitOwner returns the user the system units run as, the user invoking sudo when set
+*/
+func unitOwner() (*user.User, error) {
+	if name := os.Getenv("SUDO_USER"); valid(name) {
+		return user.Lookup(name)
+	}
+	return user.Current()
+}
+
+/*
+ownerGoPath returns the GOPATH of owner, read with go env as owner so that the
+go configuration of owner applies, $HOME/go when go cannot be run
+*/
+func ownerGoPath(owner *user.User) string {
+	goPath := filepath.Join(owner.HomeDir, "go")
+	uid, uidErr := strconv.ParseUint(owner.Uid, 10, 32)
+	gid, gidErr := strconv.ParseUint(owner.Gid, 10, 32)
+	if uidErr != nil || gidErr != nil {
+		return goPath
+	}
+	cmd := exec.Command("go", "env", "GOPATH")
+	cmd.Env = []string{"HOME=" + owner.HomeDir, "USER=" + owner.Username, "PATH=" + os.Getenv("PATH")}
+	cmd.Dir = owner.HomeDir
+	cmd.SysProcAttr = &syscall.SysProcAttr{Credential: &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}}
+	output, err := cmd.Output()
+	if err != nil {
+		return goPath
+	}
+	//the first entry of GOPATH holds the installed binaries
+	if list := filepath.SplitList(strings.TrimSpace(string(output))); len(list) > 0 && valid(list[0]) {
+		return list[0]
+	}
+	return goPath
+}
+
+/*
+useOwnerEnv points HOME and GOPATH at the home and GOPATH of the user invoking
+sudo. keiji paths are derived from HOME when the process starts, so the command
+is executed again when sudo replaced HOME with the home of root
+*/
+func useOwnerEnv() error {
+	name := os.Getenv("SUDO_USER")
+	if !valid(name) {
+		return nil
+	}
+	owner, err := user.Lookup(name)
+	if err != nil {
+		return err
+	}
+	if os.Getenv("HOME") == owner.HomeDir && valid(os.Getenv("GOPATH")) {
+		return nil
+	}
+	if err := os.Setenv("GOPATH", ownerGoPath(owner)); err != nil {
+		return err
+	}
+	if os.Getenv("HOME") == owner.HomeDir {
+		return nil
+	}
+	if err := os.Setenv("HOME", owner.HomeDir); err != nil {
+		return err
+	}
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(executable, os.Args, os.Environ())
+}
+
+/*
+chownToOwner gives the files created by root for system units to the user the
+units run as
+*/
+func chownToOwner(files ...string) error {
+	if os.Geteuid() != 0 {
+		return nil
+	}
+	owner, err := unitOwner()
+	if err != nil {
+		return err
+	}
+	uid, err := strconv.Atoi(owner.Uid)
+	if err != nil {
+		return err
+	}
+	gid, err := strconv.Atoi(owner.Gid)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if err := os.Chown(file, uid, gid); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+/*
+renderUnit returns the unit file of service for scope
+*/
+func renderUnit(service c.Service, scope string) ([]byte, error) {
+	executable, err := getServicePath(service)
+	if err != nil {
+		if errors.Is(err, cmdErrors.ErrServiceNotFound) {
+			return nil, cmdErrors.ErrServiceNotInstalled(service)
+		}
+		return nil, err
+	}
+	logPath, err := getServiceLogPath(service)
+	if err != nil {
+		return nil, err
+	}
+	data := UnitData{
+		Service:    service,
+		Home:       os.Getenv("HOME"),
+		Workspace:  paths.WORKSPACE,
+		Executable: executable,
+		PIDPath:    paths.PID_PATH(service),
+		LogPath:    logPath,
+		RestartSec: int(unitRestartSec.Seconds()),
+		WantedBy:   "default.target",
+	}
+	if scope == SystemUnits {
+		//keiji paths already belong to the owner, useOwnerEnv points HOME at its home under sudo
+		owner, err := unitOwner()
+		if err != nil {
+			return nil, err
+		}
+		data.User = owner.Username
+		data.Home = owner.HomeDir
+		data.After = []string{"network-online.target"}
+		data.Wants = []string{"network-online.target"}
+		data.WantedBy = "multi-user.target"
+	}
+	for _, dependency := range unitDependencies[service] {
+		data.After = append(data.After, unitName(dependency))
+		data.Requires = append(data.Requires, unitName(dependency))
+	}
+	var unit bytes.Buffer
+	if err := unitTemplate.Execute(&unit, data); err != nil {
+		return nil, err
+	}
+	return unit.Bytes(), nil
+}
+
+/*
+installUnits writes and enables the units of every service. dryRun prints the
+units instead. Services started through pid files are stopped first so that
+systemd takes them over
+*/
+func installUnits(scope string, start bool, dryRun bool) error {
+	dir, err := unitsPath(scope)
+	if err != nil {
+		return err
+	}
+	units := make(map[c.Service][]byte, len(c.SERVICES))
+	for _, service := range c.SERVICES {
+		if units[service], err = renderUnit(service, scope); err != nil {
+			return err
+		}
+	}
+	if dryRun {
+		for _, service := range c.SERVICES {
+			fmt.Printf("# %v\n%s\n", filepath.Join(dir, unitName(service)), units[service])
+		}
+		return nil
+	}
+	if _, err := exec.LookPath("systemctl"); err != nil {
+		return fmt.Errorf("systemctl not found, systemd units cannot be installed on this system")
+	}
+	if scope == SystemUnits && os.Geteuid() != 0 {
+		return cmdErrors.ErrPermissionDenied("installing system units must be run as root. Please use sudo or --user")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	//services started through pid files would be started twice
+	if !valid(installedUnits()) {
+		for _, service := range c.SERVICES {
+			if isServiceRunning(service) {
+				if err := stopService(service); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	names := make([]string, 0, len(c.SERVICES))
+	for _, service := range c.SERVICES {
+		//systemd appends to the log file and writes the pid file, their directories must exist
+		if _, err := logging.NewFileLogger(serviceLogsMapping[service]); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(paths.PID_PATH(service)), 0755); err != nil {
+			return err
+		}
+		//system units run as the owner, which writes the pid file
+		if scope == SystemUnits {
+			if err := chownToOwner(serviceLogsMapping[service], filepath.Dir(paths.PID_PATH(service))); err != nil {
+				return err
+			}
+		}
+		if err := os.WriteFile(filepath.Join(dir, unitName(service)), units[service], 0644); err != nil {
+			return err
+		}
+		names = append(names, unitName(service))
+	}
+	_, err = systemctl(scope, "daemon-reload")
+	if err == nil {
+		_, err = systemctl(scope, append([]string{"enable"}, names...)...)
+	}
+	if err != nil {
+		//units systemd cannot load would keep start & stop from using pid files
+		for _, name := range names {
+			os.Remove(filepath.Join(dir, name))
+		}
+		return err
+	}
+	if scope == UserUnits {
+		//user services are stopped on logout unless the user lingers
+		if owner, err := user.Current(); err == nil {
+			if output, err := exec.Command("loginctl", "enable-linger", owner.Username).CombinedOutput(); err != nil {
+				logWarn(fmt.Sprintf("could not enable lingering, services stop on logout: %v %s", err, output))
+			}
+		}
+	}
+	logInfo(fmt.Sprintf("installed %v in %v", strings.Join(names, ", "), dir))
+	if start {
+		_, err := systemctl(scope, append([]string{"start"}, names...)...)
+		return err
+	}
+	return nil
+}
+
+/*
+removeUnits stops, disables and removes the installed units
+*/
+func removeUnits() error {
+	scope := installedUnits()
+	if !valid(scope) {
+		logWarn("no keiji units installed")
+		return nil
+	}
+	if scope == SystemUnits && os.Geteuid() != 0 {
+		return cmdErrors.ErrPermissionDenied("removing system units must be run as root. Please use sudo")
+	}
+	dir, err := unitsPath(scope)
+	if err != nil {
+		return err
+	}
+	names := make([]string, 0, len(c.SERVICES))
+	for _, service := range c.SERVICES {
+		names = append(names, unitName(service))
+	}
+	if _, err := systemctl(scope, append([]string{"disable", "--now"}, names...)...); err != nil {
+		logError(err)
+	}
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if _, err := systemctl(scope, "daemon-reload"); err != nil {
+		return err
+	}
+	logInfo(fmt.Sprintf("removed %v from %v", strings.Join(names, ", "), dir))
+	return nil
+}
+
+/*
+unitAction runs a systemctl action on the unit of service, it returns false
+when the service is not managed by systemd
+*/
+func unitAction(action string, service c.Service) (bool, error) {
+	scope := installedUnits()
+	if !valid(scope) {
+		return false, nil
+	}
+	if _, err := systemctl(scope, action, unitName(service)); err != nil {
+		return true, err
+	}
+	logInfo(fmt.Sprintf("%v: %v %v", service, action, unitName(service)))
+	return true, nil
+}
+
+/*
+unitStatus returns whether the unit of service is active, its main pid and since when it is active
+*/
+func unitStatus(scope string, service c.Service) (bool, int, time.Time, error) {
+	//unix timestamps are parsed whatever the locale & time zone of systemd
+	out, err := systemctl(scope, "show", unitName(service), "--timestamp=unix", "--property=ActiveState,MainPID,ActiveEnterTimestamp")
+	if err != nil {
+		return false, 0, time.Time{}, err
+	}
+	active, pid, since := parseUnitStatus(out)
+	return active, pid, since, nil
+}
+
+/*
+parseUnitStatus parses the properties printed by systemctl show --timestamp=unix
+*/
+func parseUnitStatus(out string) (bool, int, time.Time) {
+	props := make(map[string]string)
+	for _, line := range strings.Split(out, "\n") {
+		key, value, _ := strings.Cut(line, "=")
+		props[key] = value
+	}
+	pid, _ := strconv.Atoi(props["MainPID"])
+	since := time.Time{}
+	if seconds, err := strconv.ParseInt(strings.TrimPrefix(props["ActiveEnterTimestamp"], "@"), 10, 64); err == nil && seconds > 0 {
+		since = time.Unix(seconds, 0)
+	}
+	return props["ActiveState"] == "active", pid, since
+}
+
+func newSystemInstallUnitsCMD() *cobra.Command {
+	var system, start, dryRun bool
+	cmd := &cobra.Command{
+		Use:   "install-units",
+		Short: "manage services with systemd",
+		Long: "writes and enables systemd units for the bus and scheduler so that they survive logouts & reboots\n" +
+			"and are restarted when they fail. The scheduler requires the bus and starts after it.\n" +
+			"Once installed, start, stop, restart and status delegate to systemctl",
+		Example: "keiji system install-units --start\nsudo keiji system install-units --system\nkeiji system install-units --dry-run",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			scope := UserUnits
+			if system {
+				scope = SystemUnits
+				if err := useOwnerEnv(); err != nil {
+					return err
+				}
+			}
+			if err := checkWorkSpace(); err != nil {
+				return err
+			}
+			if pid := supervisorPID(); pid > 0 && !dryRun {
+				return fmt.Errorf("services are supervised by pid %d, stop the supervisor before installing units", pid)
+			}
+			if installed := installedUnits(); valid(installed) && installed != scope {
+				return fmt.Errorf("%v units are already installed, remove them with `keiji system remove-units` first", installed)
+			}
+			return installUnits(scope, start, dryRun)
+		},
+	}
+	cmd.Flags().BoolVar(&system, "system", false, fmt.Sprintf("install system units in %v instead of user units, requires root", systemUnitsPath))
+	cmd.Flags().BoolVar(&start, "start", false, "start the services once installed")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "print the units instead of installing them")
+	return cmd
+}
+
+func newSystemRemoveUnitsCMD() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove-units",
+		Short: "stop managing services with systemd",
+		Long:  "stops, disables and removes the systemd units written by install-units",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := useOwnerEnv(); err != nil {
+				return err
+			}
+			if err := checkWorkSpace(); err != nil {
+				return err
+			}
+			return removeUnits()
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	c "github.com/aodr3w/keiji-core/constants"
+)
+
+func TestRenderUnit(t *testing.T) {
+	goPath := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(goPath, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, service := range c.SERVICES {
+		if err := os.WriteFile(filepath.Join(goPath, "bin", "keiji-"+string(service)), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("GOPATH", goPath)
+	t.Setenv("SUDO_USER", "")
+	tests := []struct {
+		name     string
+		service  c.Service
+		scope    string
+		want     []string
+		wantNone []string
+	}{
+		{
+			name:     "user scheduler",
+			service:  c.SCHEDULER,
+			scope:    UserUnits,
+			want:     []string{"After=keiji-bus.service\n", "Requires=keiji-bus.service\n", "WantedBy=default.target\n"},
+			wantNone: []string{"network-online.target", "User="},
+		},
+		{
+			name:     "user bus",
+			service:  c.TCP_BUS,
+			scope:    UserUnits,
+			want:     []string{"ExecStart=" + filepath.Join(goPath, "bin", "keiji-bus") + "\n"},
+			wantNone: []string{"After=", "Wants=", "Requires="},
+		},
+		{
+			name:    "system scheduler",
+			service: c.SCHEDULER,
+			scope:   SystemUnits,
+			want: []string{
+				"After=network-online.target keiji-bus.service\n",
+				"Wants=network-online.target\n",
+				"Requires=keiji-bus.service\n",
+				"User=",
+				"WantedBy=multi-user.target\n",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			unit, err := renderUnit(tt.service, tt.scope)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(unit), want) {
+					t.Errorf("unit does not contain %q:\n%s", want, unit)
+				}
+			}
+			for _, none := range tt.wantNone {
+				if strings.Contains(string(unit), none) {
+					t.Errorf("unit contains %q:\n%s", none, unit)
+				}
+			}
+		})
+	}
+}
+
+func TestParseUnitStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		out        string
+		wantActive bool
+		wantPID    int
+		wantSince  time.Time
+	}{
+		{
+			name:       "active",
+			out:        "ActiveState=active\nMainPID=4242\nActiveEnterTimestamp=@1772791200",
+			wantActive: true,
+			wantPID:    4242,
+			wantSince:  time.Unix(1772791200, 0),
+		},
+		{
+			name: "inactive",
+			out:  "ActiveState=inactive\nMainPID=0\nActiveEnterTimestamp=",
+		},
+		{
+			name:      "failed",
+			out:       "MainPID=0\nActiveState=failed\nActiveEnterTimestamp=@1772791200",
+			wantSince: time.Unix(1772791200, 0),
+		},
+		{
+			name:       "local timestamp is not parsed",
+			out:        "ActiveState=active\nMainPID=17\nActiveEnterTimestamp=Fri 2026-03-06 10:00:00 CET",
+			wantActive: true,
+			wantPID:    17,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			active, pid, since := parseUnitStatus(tt.out)
+			if active != tt.wantActive || pid != tt.wantPID || !since.Equal(tt.wantSince) {
+				t.Errorf("parseUnitStatus() = %v %v %v, want %v %v %v", active, pid, since, tt.wantActive, tt.wantPID, tt.wantSince)
+			}
+		})
+	}
+}
@@ -1,7 +1,9 @@
 package cmdErrors
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 )
 
 type ServiceNotFound struct {
@@ -64,3 +66,270 @@ var ErrWorkSpaceInit = func(msg string, args ...interface{}) *WorkSpaceInitError
 		fmt.Sprintf(msg, args...),
 	)
 }
+
+/*
+Exit codes returned by the keiji process. Scripts can rely on these values
+to tell failures apart without parsing the CLI output.
+*/
+const (
+	// ExitOK is returned when a command succeeds
+	ExitOK = 0
+	// ExitFailure is returned for errors without a more specific code, including invalid usage
+	ExitFailure = 1
+	// ExitWorkSpaceNotInitialized is returned when `keiji init` has not been run
+	ExitWorkSpaceNotInitialized = 10
+	// ExitWorkSpaceInit is returned when the workspace could not be created
+	ExitWorkSpaceInit = 11
+	// ExitTaskNotFound is returned when a task does not exist in the workspace or database
+	ExitTaskNotFound = 20
+	// ExitBuildFailed is returned when a task executable could not be built
+	ExitBuildFailed = 21
+	// ExitTaskFailed is returned when a manually triggered task run fails
+	ExitTaskFailed = 22
+	// ExitTaskTimedOut is returned when a manually triggered task run exceeds its timeout
+	ExitTaskTimedOut = 23
+	// ExitServiceNotInstalled is returned when a service binary is missing from the GOPATH
+	ExitServiceNotInstalled = 30
+	// ExitBusUnreachable is returned when the CLI cannot deliver a message to keiji-bus
+	ExitBusUnreachable = 31
+	// ExitPermissionDenied is returned when the user lacks the privileges for an operation
+	ExitPermissionDenied = 40
+)
+
+// ExitCoder is implemented by errors that map onto a process exit code
+type ExitCoder interface {
+	ExitCode() int
+}
+
+/*
+ExitCode returns the process exit code for err. Errors that do not implement
+ExitCoder fall back to ExitPermissionDenied for permission errors and
+ExitFailure for everything else
+*/
+func ExitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+	var coder ExitCoder
+	if errors.As(err, &coder) {
+		return coder.ExitCode()
+	}
+	if errors.Is(err, fs.ErrPermission) {
+		return ExitPermissionDenied
+	}
+	return ExitFailure
+}
+
+func (*ServiceNotFound) ExitCode() int {
+	return ExitServiceNotInstalled
+}
+
+func (*WorkSpaceInitError) ExitCode() int {
+	return ExitWorkSpaceInit
+}
+
+type WorkSpaceNotInitialized struct {
+	Message string
+}
+
+func (w *WorkSpaceNotInitialized) Error() string {
+	return w.Message
+}
+
+func NewWorkSpaceNotInitialized() *WorkSpaceNotInitialized {
+	return &WorkSpaceNotInitialized{Message: "please initialize your workspace to continue"}
+}
+
+func (w *WorkSpaceNotInitialized) Is(target error) bool {
+	_, ok := target.(*WorkSpaceNotInitialized)
+	return ok
+}
+
+func (*WorkSpaceNotInitialized) ExitCode() int {
+	return ExitWorkSpaceNotInitialized
+}
+
+type TaskNotFound struct {
+	Message string
+}
+
+func (e *TaskNotFound) Error() string {
+	return e.Message
+}
+
+func NewTaskNotFound(name string) *TaskNotFound {
+	return &TaskNotFound{Message: fmt.Sprintf("task %v not found", name)}
+}
+
+func (e *TaskNotFound) Is(target error) bool {
+	_, ok := target.(*TaskNotFound)
+	return ok
+}
+
+func (*TaskNotFound) ExitCode() int {
+	return ExitTaskNotFound
+}
+
+// BuildFailed wraps the error returned while building a task executable
+type BuildFailed struct {
+	Message string
+	Err     error
+}
+
+func (e *BuildFailed) Error() string {
+	return e.Message
+}
+
+func (e *BuildFailed) Unwrap() error {
+	return e.Err
+}
+
+func NewBuildFailed(name string, err error) *BuildFailed {
+	return &BuildFailed{
+		Message: fmt.Sprintf("failed to build task %v: %v", name, err),
+		Err:     err,
+	}
+}
+
+func (e *BuildFailed) Is(target error) bool {
+	_, ok := target.(*BuildFailed)
+	return ok
+}
+
+func (*BuildFailed) ExitCode() int {
+	return ExitBuildFailed
+}
+
+// TaskFailed wraps the error returned by a manually triggered task run
+type TaskFailed struct {
+	Message string
+	Err     error
+}
+
+func (e *TaskFailed) Error() string {
+	return e.Message
+}
+
+func (e *TaskFailed) Unwrap() error {
+	return e.Err
+}
+
+func NewTaskFailed(name string, err error) *TaskFailed {
+	return &TaskFailed{
+		Message: fmt.Sprintf("task %v failed: %v", name, err),
+		Err:     err,
+	}
+}
+
+func (e *TaskFailed) Is(target error) bool {
+	_, ok := target.(*TaskFailed)
+	return ok
+}
+
+func (*TaskFailed) ExitCode() int {
+	return ExitTaskFailed
+}
+
+// TaskTimedOut is returned when a manually triggered task run is killed at its deadline
+type TaskTimedOut struct {
+	Message string
+	Err     error
+}
+
+func (e *TaskTimedOut) Error() string {
+	return e.Message
+}
+
+func (e *TaskTimedOut) Unwrap() error {
+	return e.Err
+}
+
+func NewTaskTimedOut(name string, err error) *TaskTimedOut {
+	return &TaskTimedOut{
+		Message: fmt.Sprintf("task %v %v", name, err),
+		Err:     err,
+	}
+}
+
+func (e *TaskTimedOut) Is(target error) bool {
+	_, ok := target.(*TaskTimedOut)
+	return ok
+}
+
+func (*TaskTimedOut) ExitCode() int {
+	return ExitTaskTimedOut
+}
+
+// BusUnreachable wraps the error returned while pushing a message to keiji-bus
+type BusUnreachable struct {
+	Message string
+	Err     error
+}
+
+func (e *BusUnreachable) Error() string {
+	return e.Message
+}
+
+func (e *BusUnreachable) Unwrap() error {
+	return e.Err
+}
+
+func NewBusUnreachable(err error) *BusUnreachable {
+	return &BusUnreachable{
+		Message: fmt.Sprintf("bus unreachable, is the bus service running? %v", err),
+		Err:     err,
+	}
+}
+
+func (e *BusUnreachable) Is(target error) bool {
+	_, ok := target.(*BusUnreachable)
+	return ok
+}
+
+func (*BusUnreachable) ExitCode() int {
+	return ExitBusUnreachable
+}
+
+type PermissionDenied struct {
+	Message string
+}
+
+func (e *PermissionDenied) Error() string {
+	return e.Message
+}
+
+func NewPermissionDenied(msg string) *PermissionDenied {
+	return &PermissionDenied{Message: msg}
+}
+
+func (e *PermissionDenied) Is(target error) bool {
+	_, ok := target.(*PermissionDenied)
+	return ok
+}
+
+func (*PermissionDenied) ExitCode() int {
+	return ExitPermissionDenied
+}
+
+var ErrWorkSpaceNotInitialized = NewWorkSpaceNotInitialized()
+var ErrTaskNotFound = func(name string) *TaskNotFound {
+	return NewTaskNotFound(name)
+}
+var ErrBuildFailed = func(name string, err error) *BuildFailed {
+	return NewBuildFailed(name, err)
+}
+var ErrTaskFailed = func(name string, err error) *TaskFailed {
+	return NewTaskFailed(name, err)
+}
+var ErrTaskTimedOut = func(name string, err error) *TaskTimedOut {
+	return NewTaskTimedOut(name, err)
+}
+var ErrServiceNotInstalled = func(service interface{}) *ServiceNotFound {
+	return &ServiceNotFound{fmt.Sprintf("service %v is not installed, run `keiji init` to install it", service)}
+}
+var ErrBusUnreachable = func(err error) *BusUnreachable {
+	return NewBusUnreachable(err)
+}
+var ErrPermissionDenied = func(msg string, args ...interface{}) *PermissionDenied {
+	return NewPermissionDenied(fmt.Sprintf(msg, args...))
+}
@@ -0,0 +1,367 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CRON_PATH holds a file per cron task with the last fire time claimed by one of its runs
+var CRON_PATH = filepath.Join(RUNS_PATH, "cron")
+
+// cronDescriptors are the shorthands accepted in place of an expression, with leading seconds
+var cronDescriptors = map[string]string{
+	"@yearly":   "0 0 0 1 1 *",
+	"@annually": "0 0 0 1 1 *",
+	"@monthly":  "0 0 0 1 * *",
+	"@weekly":   "0 0 0 * * 0",
+	"@daily":    "0 0 0 * * *",
+	"@midnight": "0 0 0 * * *",
+	"@hourly":   "0 0 * * * *",
+}
+
+var cronMonths = map[string]int{
+	"jan": 1, "feb": 2, "mar": 3, "apr": 4, "may": 5, "jun": 6,
+	"jul": 7, "aug": 8, "sep": 9, "oct": 10, "nov": 11, "dec": 12,
+}
+
+var cronDays = map[string]int{
+	"sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+}
+
+/*
+cronField describes a field of a cron expression
+*/
+type cronField struct {
+	name  string
+	min   int
+	max   int
+	names map[string]int
+}
+
+var cronFields = []cronField{
+	{"second", 0, 59, nil},
+	{"minute", 0, 59, nil},
+	{"hour", 0, 23, nil},
+	{"day of month", 1, 31, nil},
+	{"month", 1, 12, cronMonths},
+	{"day of week", 0, 6, cronDays},
+}
+
+/*
+CronSchedule holds the values matched by every field of a cron expression as bit sets
+*/
+type CronSchedule struct {
+	second, minute, hour, dom, month, dow uint64
+	//anyDom and anyDow are set by * or ?, a day then only has to match the other field
+	anyDom, anyDow bool
+}
+
+/*
+ParseCron parses a standard 5 field cron expression, 6 fields with leading
+seconds or a descriptor such as @hourly
+*/
+func ParseCron(expr string) (*CronSchedule, error) {
+	schedule, err := parseCron(strings.TrimSpace(expr))
+	if err != nil {
+		return nil, fmt.Errorf("invalid cron expression %q: %v (use 5 fields, or 6 with leading seconds e.g \"0 */15 9-17 * * MON-FRI\")", expr, err)
+	}
+	return schedule, nil
+}
+
+func parseCron(expr string) (*CronSchedule, error) {
+	if strings.HasPrefix(expr, "@") {
+		descriptor, ok := cronDescriptors[strings.ToLower(expr)]
+		if !ok {
+			return nil, fmt.Errorf("unknown descriptor %v", expr)
+		}
+		expr = descriptor
+	}
+	fields := strings.Fields(expr)
+	switch len(fields) {
+	case 5:
+		fields = append([]string{"0"}, fields...)
+	case 6:
+	default:
+		return nil, fmt.Errorf("expected 5 or 6 fields, got %d", len(fields))
+	}
+	bits := make([]uint64, len(fields))
+	wildcard := make([]bool, len(fields))
+	for i, field := range fields {
+		var err error
+		if bits[i], wildcard[i], err = cronFields[i].parse(field); err != nil {
+			return nil, err
+		}
+	}
+	return &CronSchedule{
+		second: bits[0],
+		minute: bits[1],
+		hour:   bits[2],
+		dom:    bits[3],
+		month:  bits[4],
+		dow:    bits[5],
+		anyDom: wildcard[3],
+		anyDow: wildcard[5],
+	}, nil
+}
+
+/*
+parse returns the values matched by a comma separated list of values, ranges
+and steps e.g 1,15 or 9-17 or 0-59/15, wildcard is true when the field is * or ?
+*/
+func (f cronField) parse(field string) (bits uint64, wildcard bool, err error) {
+	for _, part := range strings.Split(field, ",") {
+		span, stepText, hasStep := strings.Cut(part, "/")
+		start, end := f.min, f.max
+		switch {
+		case span == "*" || span == "?":
+			wildcard = !hasStep
+		case strings.Contains(span, "-"):
+			low, high, _ := strings.Cut(span, "-")
+			var err error
+			if start, err = f.value(low); err != nil {
+				return 0, false, err
+			}
+			if end, err = f.value(high); err != nil {
+				return 0, false, err
+			}
+		default:
+			value, err := f.value(span)
+			if err != nil {
+				return 0, false, err
+			}
+			//a single value with a step e.g 5/15 runs up to the end of the range
+			start = value
+			if !hasStep {
+				end = value
+			}
+		}
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, false, fmt.Errorf("invalid step %q in %v field", stepText, f.name)
+			}
+		}
+		if start > end {
+			return 0, false, fmt.Errorf("invalid range %q in %v field", span, f.name)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, wildcard, nil
+}
+
+func (f cronField) value(text string) (int, error) {
+	if value, ok := f.names[strings.ToLower(text)]; ok {
+		return value, nil
+	}
+	value, err := strconv.Atoi(text)
+	if err != nil || value < f.min || value > f.max {
+		return 0, fmt.Errorf("invalid %v %q, must be between %d and %d", f.name, text, f.min, f.max)
+	}
+	return value, nil
+}
+
+/*
+dayMatches applies the cron rule for days: when both day fields are restricted
+a day matching either of them fires
+*/
+func (s *CronSchedule) dayMatches(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.anyDom || s.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+/*
+Next returns the first fire time strictly after t in the location of t, the
+zero time if the expression never fires e.g on February 30th. Fire times that
+fall in a DST gap are skipped, those in the hour repeated when clocks go back
+fire at both offsets
+*/
+func (s *CronSchedule) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = t.Add(time.Second - time.Duration(t.Nanosecond()))
+	//fields are advanced from the largest, resetting the smaller ones once on the first change
+	reset := false
+	limit := t.Year() + 5
+wrap:
+	if t.Year() > limit {
+		return time.Time{}
+	}
+	for s.month&(1<<uint(t.Month())) == 0 {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 1, 0)
+		if t.Month() == time.January {
+			goto wrap
+		}
+	}
+	for !s.dayMatches(t) {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, loc)
+		}
+		t = t.AddDate(0, 0, 1)
+		//midnight does not exist on some DST changes
+		if t.Hour() != 0 {
+			if t.Hour() > 12 {
+				t = t.Add(time.Duration(24-t.Hour()) * time.Hour)
+			} else {
+				t = t.Add(time.Duration(-t.Hour()) * time.Hour)
+			}
+		}
+		if t.Day() == 1 {
+			goto wrap
+		}
+	}
+	for s.hour&(1<<uint(t.Hour())) == 0 {
+		if !reset {
+			reset = true
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, loc)
+		}
+		t = t.Add(time.Hour)
+		if t.Hour() == 0 {
+			goto wrap
+		}
+	}
+	for s.minute&(1<<uint(t.Minute())) == 0 {
+		if !reset {
+			reset = true
+			t = t.Truncate(time.Minute)
+		}
+		t = t.Add(time.Minute)
+		if t.Minute() == 0 {
+			goto wrap
+		}
+	}
+	for s.second&(1<<uint(t.Second())) == 0 {
+		if !reset {
+			reset = true
+			t = t.Truncate(time.Second)
+		}
+		t = t.Add(time.Second)
+		if t.Second() == 0 {
+			goto wrap
+		}
+	}
+	return t
+}
+
+/*
+Location returns the TIME_ZONE configured in the workspace settings
+*/
+func Location() (*time.Location, error) {
+	tz := os.Getenv("TIME_ZONE")
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TIME_ZONE %q in settings.conf: %v", tz, err)
+	}
+	return loc, nil
+}
+
+/*
+lastFire returns the latest fire time of schedule in (after, now], false if
+there is none. Fire times missed e.g while the scheduler was stopped are
+coalesced into the latest one
+*/
+func lastFire(schedule *CronSchedule, after time.Time, now time.Time) (time.Time, bool) {
+	//the window ending at now is doubled until it holds a fire time or reaches after
+	for span := time.Second; ; span *= 2 {
+		from := now.Add(-span)
+		if from.Before(after) {
+			from = after
+		}
+		next := schedule.Next(from)
+		if !next.IsZero() && !next.After(now) {
+			for {
+				following := schedule.Next(next)
+				if following.IsZero() || following.After(now) {
+					return next, true
+				}
+				next = following
+			}
+		}
+		if !from.After(after) {
+			return time.Time{}, false
+		}
+	}
+}
+
+func (r *runner) cronFile() string {
+	return filepath.Join(CRON_PATH, r.task.Name)
+}
+
+/*
+lastFireTime returns the latest fire time executed or claimed by a run of the
+task. Fire times before the task was built are never executed
+*/
+func (r *runner) lastFireTime() (time.Time, error) {
+	last := r.settings.UpdatedAt
+	runs := []*TaskRun{}
+	err := r.repo.DB.Where("task_name = ? AND fire_time IS NOT NULL", r.task.Name).Order("fire_time desc").Limit(1).Find(&runs).Error
+	if err != nil {
+		return last, err
+	}
+	if len(runs) > 0 && runs[0].FireTime.After(last) {
+		last = *runs[0].FireTime
+	}
+	//the claim of a run that has not been recorded yet
+	if content, err := os.ReadFile(r.cronFile()); err == nil {
+		claimed, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(content)))
+		if err == nil && claimed.After(last) {
+			last = claimed
+		}
+	}
+	return last, nil
+}
+
+/*
+due returns false if the run is a scheduled run of a cron task and the
+expression did not fire since the fire time executed by the previous run.
+Otherwise the run claims the latest fire time, so that polls overlapping a
+slow run never execute the same fire time twice
+*/
+func (r *runner) due(now time.Time) (bool, error) {
+	if r.trigger != TriggerSchedule || r.settings.Cron == "" {
+		return true, nil
+	}
+	schedule, err := ParseCron(r.settings.Cron)
+	if err != nil {
+		return false, err
+	}
+	loc, err := Location()
+	if err != nil {
+		return false, err
+	}
+	unlock, err := lockRuns()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	after, err := r.lastFireTime()
+	if err != nil {
+		return false, err
+	}
+	fire, ok := lastFire(schedule, after.In(loc), now.In(loc))
+	if !ok {
+		return false, nil
+	}
+	r.fireTime = &fire
+	if err := os.MkdirAll(CRON_PATH, 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(r.cronFile(), []byte(fire.Format(time.RFC3339Nano)), 0644)
+}
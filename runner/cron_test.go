@@ -0,0 +1,325 @@
+package runner
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	from := time.Date(2026, time.March, 6, 10, 7, 30, 0, time.UTC) //a Friday
+	tests := []struct {
+		name    string
+		expr    string
+		want    time.Time
+		wantErr bool
+	}{
+		{
+			name: "5 fields",
+			expr: "*/15 9-17 * * MON-FRI",
+			want: time.Date(2026, time.March, 6, 10, 15, 0, 0, time.UTC),
+		},
+		{
+			name: "6 fields with leading seconds",
+			expr: "30 */15 9-17 * * MON-FRI",
+			want: time.Date(2026, time.March, 6, 10, 15, 30, 0, time.UTC),
+		},
+		{
+			name: "6 fields every 20 seconds",
+			expr: "*/20 * * * * *",
+			want: time.Date(2026, time.March, 6, 10, 7, 40, 0, time.UTC),
+		},
+		{
+			name: "5 fields later the same day",
+			expr: "0 17 * * MON-FRI",
+			want: time.Date(2026, time.March, 6, 17, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekdays skip the weekend",
+			expr: "0 9 * * mon-fri",
+			want: time.Date(2026, time.March, 9, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "lists",
+			expr: "0 8,12 * * *",
+			want: time.Date(2026, time.March, 6, 12, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "single value with a step",
+			expr: "5/20 * * * *",
+			want: time.Date(2026, time.March, 6, 10, 25, 0, 0, time.UTC),
+		},
+		{
+			name: "month names",
+			expr: "0 0 1 JUN,DEC *",
+			want: time.Date(2026, time.June, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week when both are restricted",
+			expr: "0 0 13 * MON",
+			want: time.Date(2026, time.March, 9, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month and any day of week",
+			expr: "0 0 13 * ?",
+			want: time.Date(2026, time.March, 13, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "descriptor",
+			expr: "@hourly",
+			want: time.Date(2026, time.March, 6, 11, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "weekly descriptor",
+			expr: "@weekly",
+			want: time.Date(2026, time.March, 8, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "surrounding spaces",
+			expr: "  0 0 1 * *  ",
+			want: time.Date(2026, time.April, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "never fires",
+			expr: "0 0 30 2 *",
+			want: time.Time{},
+		},
+		{
+			name:    "4 fields",
+			expr:    "0 9 * *",
+			wantErr: true,
+		},
+		{
+			name:    "7 fields",
+			expr:    "0 0 9 * * MON 2026",
+			wantErr: true,
+		},
+		{
+			name:    "out of range",
+			expr:    "0 25 * * *",
+			wantErr: true,
+		},
+		{
+			name:    "reversed range",
+			expr:    "0 17-9 * * *",
+			wantErr: true,
+		},
+		{
+			name:    "zero step",
+			expr:    "*/0 * * * *",
+			wantErr: true,
+		},
+		{
+			name:    "unknown name",
+			expr:    "0 9 * * MONDAY",
+			wantErr: true,
+		},
+		{
+			name:    "unknown descriptor",
+			expr:    "@every 5m",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			expr:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCron(%q) error = %v, wantErr %v", tt.expr, err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got := schedule.Next(from); !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", from, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCronScheduleNextDST(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skipf("time zone database unavailable: %v", err)
+	}
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "matched in the location of the time",
+			expr: "0 9 * * *",
+			from: time.Date(2026, time.March, 6, 10, 0, 0, 0, newYork),
+			want: time.Date(2026, time.March, 7, 14, 0, 0, 0, time.UTC), //09:00 EST
+		},
+		{
+			name: "across the start of DST",
+			expr: "0 9 * * *",
+			from: time.Date(2026, time.March, 7, 10, 0, 0, 0, newYork),
+			want: time.Date(2026, time.March, 8, 13, 0, 0, 0, time.UTC), //09:00 EDT
+		},
+		{
+			name: "fire time in the DST gap is skipped",
+			expr: "30 2 * * *",
+			from: time.Date(2026, time.March, 8, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, time.March, 9, 6, 30, 0, 0, time.UTC), //02:30 EDT the next day
+		},
+		{
+			name: "across the end of DST",
+			expr: "0 9 * * *",
+			from: time.Date(2026, time.October, 31, 10, 0, 0, 0, newYork),
+			want: time.Date(2026, time.November, 1, 14, 0, 0, 0, time.UTC), //09:00 EST
+		},
+		{
+			name: "repeated hour fires in daylight time",
+			expr: "30 1 * * *",
+			from: time.Date(2026, time.November, 1, 0, 0, 0, 0, newYork),
+			want: time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC), //01:30 EDT
+		},
+		{
+			name: "repeated hour fires again in standard time",
+			expr: "30 1 * * *",
+			from: time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC).In(newYork),
+			want: time.Date(2026, time.November, 1, 6, 30, 0, 0, time.UTC), //01:30 EST
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := schedule.Next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("Next(%v) = %v, want %v", tt.from, got, tt.want)
+			}
+			if got.Location() != newYork {
+				t.Errorf("Next(%v) location = %v, want %v", tt.from, got.Location(), newYork)
+			}
+		})
+	}
+}
+
+func TestLastFire(t *testing.T) {
+	at := func(hour, min, sec int) time.Time {
+		return time.Date(2026, time.March, 6, hour, min, sec, 0, time.UTC)
+	}
+	tests := []struct {
+		name   string
+		expr   string
+		after  time.Time
+		now    time.Time
+		want   time.Time
+		wantOk bool
+	}{
+		{
+			name:   "fire time since the previous one",
+			expr:   "*/15 * * * *",
+			after:  at(10, 0, 0),
+			now:    at(10, 15, 20),
+			want:   at(10, 15, 0),
+			wantOk: true,
+		},
+		{
+			name:   "fire time at now",
+			expr:   "*/15 * * * *",
+			after:  at(10, 0, 0),
+			now:    at(10, 15, 0),
+			want:   at(10, 15, 0),
+			wantOk: true,
+		},
+		{
+			name:  "fire time already executed",
+			expr:  "*/15 * * * *",
+			after: at(10, 15, 0),
+			now:   at(10, 16, 0),
+		},
+		{
+			name:   "poll started late",
+			expr:   "*/15 * * * *",
+			after:  at(10, 0, 0),
+			now:    at(10, 17, 45),
+			want:   at(10, 15, 0),
+			wantOk: true,
+		},
+		{
+			name:  "no fire time yet",
+			expr:  "*/15 * * * *",
+			after: at(10, 15, 0),
+			now:   at(10, 29, 59),
+		},
+		{
+			name:   "missed fire times are coalesced",
+			expr:   "*/15 * * * *",
+			after:  at(6, 0, 0),
+			now:    at(10, 20, 0),
+			want:   at(10, 15, 0),
+			wantOk: true,
+		},
+		{
+			name:   "fire time days ago",
+			expr:   "0 9 * * MON",
+			after:  time.Date(2026, time.February, 23, 9, 0, 0, 0, time.UTC),
+			now:    at(10, 0, 0),
+			want:   time.Date(2026, time.March, 2, 9, 0, 0, 0, time.UTC),
+			wantOk: true,
+		},
+		{
+			name:   "seconds field",
+			expr:   "30 * * * * *",
+			after:  at(10, 0, 0),
+			now:    at(10, 0, 30),
+			want:   at(10, 0, 30),
+			wantOk: true,
+		},
+		{
+			name:  "never fires",
+			expr:  "0 0 30 2 *",
+			after: at(0, 0, 0),
+			now:   at(10, 0, 0),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			schedule, err := ParseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := lastFire(schedule, tt.after, tt.now)
+			if ok != tt.wantOk || !got.Equal(tt.want) {
+				t.Errorf("lastFire(%q, %v, %v) = %v %v, want %v %v", tt.expr, tt.after, tt.now, got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeZone string
+		want     string
+		wantErr  bool
+	}{
+		{name: "unset", timeZone: "", want: time.Local.String()},
+		{name: "utc", timeZone: "UTC", want: "UTC"},
+		{name: "iana name", timeZone: "Europe/Berlin", want: "Europe/Berlin"},
+		{name: "unknown", timeZone: "Mars/Olympus_Mons", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TIME_ZONE", tt.timeZone)
+			loc, err := Location()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Location() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && loc.String() != tt.want {
+				t.Errorf("Location() = %v, want %v", loc, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+package runner
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/aodr3w/keiji-core/db"
+)
+
+// SkipDownstreamEnv prevents a successful run from starting the tasks depending on it
+const SkipDownstreamEnv = "KEIJI_SKIP_DOWNSTREAM"
+
+// DOWNSTREAM_PATH holds a file per downstream task with the upstream runs that last started it
+var DOWNSTREAM_PATH = filepath.Join(RUNS_PATH, "downstream")
+
+/*
+latestRun returns the most recent run of the task named name, nil if it never ran
+*/
+func latestRun(repo *db.Repo, name string) (*TaskRun, error) {
+	runs := make([]*TaskRun, 0, 1)
+	err := repo.DB.Where("task_name = ?", name).Order("start DESC").Limit(1).Find(&runs).Error
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+/*
+UpstreamSucceeded returns true if the latest run of every upstream task of
+the task named name succeeded after the task last ran
+*/
+func UpstreamSucceeded(repo *db.Repo, name string, upstream []string) (bool, error) {
+	last, err := latestRun(repo, name)
+	if err != nil {
+		return false, err
+	}
+	for _, u := range upstream {
+		run, err := latestRun(repo, u)
+		if err != nil {
+			return false, err
+		}
+		if run == nil || run.Status != StatusSuccess {
+			return false, nil
+		}
+		if last != nil && run.End.Before(last.Start) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+/*
+ClaimDownstream returns true if the upstream tasks of the task named name have
+all succeeded since it last ran and it was not started yet for their latest
+runs. Upstream tasks finishing together would otherwise both start it
+*/
+func ClaimDownstream(repo *db.Repo, name string, upstream []string) (bool, error) {
+	unlock, err := lockRuns()
+	if err != nil {
+		return false, err
+	}
+	defer unlock()
+	ready, err := UpstreamSucceeded(repo, name, upstream)
+	if err != nil || !ready {
+		return false, err
+	}
+	runs := make([]string, 0, len(upstream))
+	for _, u := range upstream {
+		run, err := latestRun(repo, u)
+		if err != nil {
+			return false, err
+		}
+		runs = append(runs, fmt.Sprintf("%v %d\n", u, run.ID))
+	}
+	claim := strings.Join(runs, "")
+	file := filepath.Join(DOWNSTREAM_PATH, name)
+	if content, err := os.ReadFile(file); err == nil && string(content) == claim {
+		return false, nil
+	}
+	if err := os.MkdirAll(DOWNSTREAM_PATH, 0755); err != nil {
+		return false, err
+	}
+	return true, os.WriteFile(file, []byte(claim), 0644)
+}
+
+/*
+ready returns false if the run is a scheduled run of a task whose upstream tasks
+have not all succeeded since it last ran. Manual runs are not gated and runs
+started by an upstream task were checked before being started
+*/
+func (r *runner) ready() (bool, error) {
+	if r.trigger != TriggerSchedule || len(r.settings.DependsOn) == 0 {
+		return true, nil
+	}
+	return UpstreamSucceeded(r.repo, r.task.Name, r.settings.DependsOn)
+}
+
+/*
+runDownstream starts the tasks depending on the task whose upstream tasks have
+all succeeded. They run in the background with their output appended to their
+log file, each of them starts its own downstream tasks once it succeeds
+*/
+func (r *runner) runDownstream() {
+	if r.skipDownstream {
+		return
+	}
+	all := make([]*TaskSettings, 0)
+	if err := r.repo.DB.Find(&all).Error; err != nil {
+		log.Printf("failed to load the tasks depending on %v: %v", r.task.Name, err)
+		return
+	}
+	for _, settings := range all {
+		if !contains(settings.DependsOn, r.task.Name) {
+			continue
+		}
+		if err := r.startDownstream(settings); err != nil {
+			log.Printf("failed to start downstream task %v: %v", settings.TaskName, err)
+		}
+	}
+}
+
+/*
+startDownstream starts the executable of the downstream task if it is ready to
+run and no other upstream task started it
+*/
+func (r *runner) startDownstream(settings *TaskSettings) error {
+	claimed, err := ClaimDownstream(r.repo, settings.TaskName, settings.DependsOn)
+	if err != nil {
+		return err
+	}
+	if !claimed {
+		log.Printf("not starting %v, waiting for its other upstream tasks %v or already started by one of them", settings.TaskName, settings.DependsOn)
+		return nil
+	}
+	task, err := r.repo.GetTaskByName(settings.TaskName)
+	if err != nil {
+		return err
+	}
+	if task.IsDisabled || task.IsError {
+		log.Printf("not starting %v, the task is disabled or in error state", task.Name)
+		return nil
+	}
+	secrets, err := taskSecrets(task.Name, r.secretsKey)
+	if err != nil {
+		return err
+	}
+	out, err := os.OpenFile(task.LogPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	cmd := exec.Command(task.Executable, "--run")
+	cmd.Env = append(r.environ, fmt.Sprintf("%s=%s", TriggerEnv, TriggerUpstream))
+	//the secrets are passed over a pipe, the secrets key would stay readable in the environment of the run
+	send, err := PassSecrets(cmd, secrets)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = out
+	cmd.Stderr = out
+	//the downstream run outlives this process
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+	if err := cmd.Start(); err != nil {
+		send()
+		return err
+	}
+	if err := send(); err != nil {
+		log.Printf("failed to pass the secrets of downstream task %v: %v", task.Name, err)
+	}
+	log.Printf("started downstream task %v", task.Name)
+	return cmd.Process.Release()
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
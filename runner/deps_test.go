@@ -0,0 +1,76 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+)
+
+func TestClaimDownstream(t *testing.T) {
+	defer func(path string) { DOWNSTREAM_PATH = path }(DOWNSTREAM_PATH)
+	defer func(path string) { RUNS_PATH = path }(RUNS_PATH)
+	RUNS_PATH = t.TempDir()
+	DOWNSTREAM_PATH = t.TempDir()
+	repo := &db.Repo{DB: testDB(t)}
+	start := time.Date(2026, time.March, 6, 10, 0, 0, 0, time.UTC)
+	record := func(name string, status string, minute int) {
+		at := start.Add(time.Duration(minute) * time.Minute)
+		run := &TaskRun{TaskName: name, Start: at, End: at.Add(30 * time.Second), Status: status}
+		if err := repo.DB.Create(run).Error; err != nil {
+			t.Fatal(err)
+		}
+	}
+	upstream := []string{"extract_orders", "extract_users"}
+	steps := []struct {
+		name string
+		run  func()
+		want bool
+	}{
+		{
+			name: "one upstream task succeeded",
+			run:  func() { record("extract_orders", StatusSuccess, 0) },
+			want: false,
+		},
+		{
+			name: "both upstream tasks succeeded",
+			run:  func() { record("extract_users", StatusSuccess, 0) },
+			want: true,
+		},
+		{
+			name: "the other upstream task finished at the same time",
+			run:  func() {},
+			want: false,
+		},
+		{
+			name: "downstream ran and an upstream task succeeded again",
+			run: func() {
+				record("load", StatusSuccess, 1)
+				record("extract_orders", StatusSuccess, 2)
+				record("extract_users", StatusSuccess, 2)
+			},
+			want: true,
+		},
+		{
+			name: "an upstream task failed",
+			run: func() {
+				record("load", StatusSuccess, 3)
+				record("extract_orders", StatusSuccess, 4)
+				record("extract_users", StatusError, 4)
+			},
+			want: false,
+		},
+	}
+	for _, step := range steps {
+		t.Run(step.name, func(t *testing.T) {
+			step.run()
+			got, err := ClaimDownstream(repo, "load", upstream)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != step.want {
+				t.Errorf("ClaimDownstream() = %v, want %v", got, step.want)
+			}
+		})
+	}
+}
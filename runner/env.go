@@ -0,0 +1,118 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/aodr3w/keiji-core/paths"
+	"github.com/joho/godotenv"
+)
+
+// SecretsFDEnv names the file descriptor the decrypted secrets of a run are passed
+// through by `keiji task run` and upstream runs, so that the secrets key never
+// reaches the task process
+const SecretsFDEnv = "KEIJI_SECRETS_FD"
+
+// SecretMask replaces the values of secrets in the recorded errors of runs
+const SecretMask = "******"
+
+// InternalEnv lists the variables keiji configures runs with, Function never sees them
+var InternalEnv = []string{TriggerEnv, TimeoutEnv, SkipDownstreamEnv, ForceEnv, SecretsKeyEnv, SecretsFDEnv}
+
+/*
+CleanEnv returns environ without the variables of InternalEnv
+*/
+func CleanEnv(environ []string) []string {
+	clean := make([]string, 0, len(environ))
+	for _, pair := range environ {
+		key, _, _ := strings.Cut(pair, "=")
+		if !contains(InternalEnv, key) {
+			clean = append(clean, pair)
+		}
+	}
+	return clean
+}
+
+/*
+PassSecrets hands secrets to the runner started by cmd through a pipe named by
+SecretsFDEnv. The returned send function is called once cmd started, it writes
+the secrets and closes both ends of the pipe in this process
+*/
+func PassSecrets(cmd *exec.Cmd, secrets map[string]string) (send func() error, err error) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	//descriptors 0-2 are stdin, stdout & stderr, ExtraFiles start at 3
+	cmd.ExtraFiles = append(cmd.ExtraFiles, reader)
+	cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%d", SecretsFDEnv, 2+len(cmd.ExtraFiles)))
+	return func() error {
+		//the started process holds its own copy of the read end
+		reader.Close()
+		defer writer.Close()
+		return json.NewEncoder(writer).Encode(secrets)
+	}, nil
+}
+
+/*
+readSecrets returns the secrets of the run, passed through SecretsFDEnv by
+`keiji task run` and upstream runs, or decrypted from the secrets store for
+scheduled runs
+*/
+func readSecrets(name string) (map[string]string, error) {
+	value := os.Getenv(SecretsFDEnv)
+	if value == "" {
+		return TaskSecrets(name)
+	}
+	fd, err := strconv.Atoi(value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %v %q", SecretsFDEnv, value)
+	}
+	f := os.NewFile(uintptr(fd), "secrets")
+	defer f.Close()
+	secrets := make(map[string]string)
+	if err := json.NewDecoder(f).Decode(&secrets); err != nil {
+		return nil, fmt.Errorf("failed to read the secrets of task %v: %v", name, err)
+	}
+	return secrets, nil
+}
+
+/*
+loadEnv sets the variables of the task's .env file, then its secrets, in the
+environment of the process, overriding inherited values. The variables of
+InternalEnv are removed so that they are not visible to Function or the
+processes it starts, and secret values are masked in the recorded errors
+*/
+func (r *runner) loadEnv() error {
+	env, err := godotenv.Read(filepath.Join(paths.TASKS_PATH, r.task.Name, ".env"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	secrets, err := readSecrets(r.task.Name)
+	if err != nil {
+		return err
+	}
+	for _, key := range InternalEnv {
+		os.Unsetenv(key)
+	}
+	pairs := make([]string, 0, 2*len(secrets))
+	for _, value := range secrets {
+		if value != "" {
+			pairs = append(pairs, value, SecretMask)
+		}
+	}
+	r.mask = strings.NewReplacer(pairs...)
+	for _, values := range []map[string]string{env, secrets} {
+		for key, value := range values {
+			if err := os.Setenv(key, value); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
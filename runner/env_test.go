@@ -0,0 +1,47 @@
+package runner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCleanEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		environ []string
+		want    []string
+	}{
+		{
+			name:    "no internal variables",
+			environ: []string{"HOME=/home/keiji", "PATH=/usr/bin"},
+			want:    []string{"HOME=/home/keiji", "PATH=/usr/bin"},
+		},
+		{
+			name:    "secrets key",
+			environ: []string{"HOME=/home/keiji", "KEIJI_SECRETS_KEY=c2VjcmV0", "PATH=/usr/bin"},
+			want:    []string{"HOME=/home/keiji", "PATH=/usr/bin"},
+		},
+		{
+			name:    "run configuration",
+			environ: []string{"KEIJI_TRIGGER=manual", "KEIJI_TIMEOUT=5s", "KEIJI_FORCE=1", "KEIJI_SKIP_DOWNSTREAM=1", "KEIJI_SECRETS_FD=3"},
+			want:    []string{},
+		},
+		{
+			name:    "prefix of an internal variable",
+			environ: []string{"KEIJI_SECRETS_KEY_ID=1", "KEIJI_TRIGGERED=yes"},
+			want:    []string{"KEIJI_SECRETS_KEY_ID=1", "KEIJI_TRIGGERED=yes"},
+		},
+		{
+			name:    "value containing an internal variable",
+			environ: []string{"NOTE=KEIJI_SECRETS_KEY=c2VjcmV0"},
+			want:    []string{"NOTE=KEIJI_SECRETS_KEY=c2VjcmV0"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CleanEnv(tt.environ); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("CleanEnv() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
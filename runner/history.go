@@ -0,0 +1,97 @@
+package runner
+
+import (
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"gorm.io/gorm"
+)
+
+// statuses of a task run
+const (
+	StatusSuccess     = "success"
+	StatusError       = "error"
+	StatusTimeout     = "timeout"
+	StatusInterrupted = "interrupted"
+)
+
+// Statuses lists every status a run can be recorded with
+var Statuses = []string{StatusSuccess, StatusError, StatusTimeout, StatusInterrupted}
+
+// triggers of a task run
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+	TriggerUpstream = "upstream"
+)
+
+/*
+TaskRun records a single execution of a task
+*/
+type TaskRun struct {
+	gorm.Model
+	TaskName  string `gorm:"index"`
+	Start     time.Time
+	End       time.Time
+	Duration  time.Duration
+	ExitCode  int
+	Attempt   int
+	Status    string `gorm:"index"`
+	ErrorTxt  string
+	LogOffset int64
+	Trigger   string
+	//FireTime is the fire time of the cron expression executed by a scheduled run
+	FireTime *time.Time `gorm:"index"`
+}
+
+func (TaskRun) TableName() string {
+	return "task_runs"
+}
+
+// exitCodes maps the status of a run onto the exit code of the task executable
+var exitCodes = map[string]int{
+	StatusSuccess:     ExitSuccess,
+	StatusError:       ExitError,
+	StatusTimeout:     ExitTimeout,
+	StatusInterrupted: ExitInterrupted,
+}
+
+/*
+RecordKilled appends to the history of the task named name an interrupted run
+that started at start and was killed with SIGKILL, which the run cannot record
+*/
+func RecordKilled(repo *db.Repo, name string, start time.Time, reason string) error {
+	end := time.Now()
+	return repo.DB.Create(&TaskRun{
+		TaskName: name,
+		Start:    start,
+		End:      end,
+		Duration: end.Sub(start),
+		ExitCode: exitCodes[StatusInterrupted],
+		Status:   StatusInterrupted,
+		ErrorTxt: reason,
+	}).Error
+}
+
+/*
+save appends the outcome of the current attempt to the task's run history
+*/
+func (r *runner) save(status string, err error) error {
+	end := time.Now()
+	run := &TaskRun{
+		TaskName:  r.task.Name,
+		Start:     r.start,
+		End:       end,
+		Duration:  end.Sub(r.start),
+		ExitCode:  exitCodes[status],
+		Attempt:   r.attempt,
+		Status:    status,
+		LogOffset: r.logOffset,
+		Trigger:   r.trigger,
+		FireTime:  r.fireTime,
+	}
+	if err != nil {
+		run.ErrorTxt = r.mask.Replace(err.Error())
+	}
+	return r.repo.DB.Create(run).Error
+}
@@ -0,0 +1,323 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"github.com/aodr3w/keiji-core/paths"
+)
+
+// ForceEnv makes a run ignore the overlap policy of its task, set by `keiji task run --force`
+const ForceEnv = "KEIJI_FORCE"
+
+// ExitSkipped is returned by manual runs refused by the overlap policy of their task
+const ExitSkipped = 75
+
+// queuePollInterval is how often a queued run checks whether it may start
+const queuePollInterval = time.Second
+
+// RUNS_PATH holds a file per executing run, named <task>.<pid> and holding the start time of the process
+var RUNS_PATH = filepath.Join(paths.SYSTEM_ROOT, "runs")
+
+// procRoot exposes the start time of processes, it is missing on macOS where runs are only checked with kill
+var procRoot = "/proc"
+
+/*
+MaxConcurrency returns the MAX_CONCURRENCY configured in the workspace settings,
+0 means that the number of running tasks is not limited
+*/
+func MaxConcurrency() (int, error) {
+	value := os.Getenv("MAX_CONCURRENCY")
+	if value == "" {
+		return 0, nil
+	}
+	max, err := strconv.Atoi(value)
+	if err != nil || max < 0 {
+		return 0, fmt.Errorf("invalid MAX_CONCURRENCY %q in settings.conf, must be a positive number or 0 for no limit", value)
+	}
+	return max, nil
+}
+
+/*
+lockRuns serializes the admission of runs across processes, the returned
+function releases the lock
+*/
+func lockRuns() (func(), error) {
+	if err := os.MkdirAll(RUNS_PATH, 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(filepath.Join(RUNS_PATH, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return func() {
+		syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+/*
+RunInstances returns the pids of the executing runs of the task named name, all
+tasks when name is *. Files left behind by runs that died are removed
+*/
+func RunInstances(name string) ([]int, error) {
+	files, err := filepath.Glob(filepath.Join(RUNS_PATH, name+".*"))
+	if err != nil {
+		return nil, err
+	}
+	pids := make([]int, 0, len(files))
+	for _, file := range files {
+		ext := filepath.Ext(file)
+		pid, err := strconv.Atoi(strings.TrimPrefix(ext, "."))
+		if err != nil {
+			continue
+		}
+		//the glob of task a also matches the runs of a task named a.b
+		if name != "*" && strings.TrimSuffix(filepath.Base(file), ext) != name {
+			continue
+		}
+		if !runAlive(file, pid) {
+			os.Remove(file)
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+/*
+procStart returns the state and the start time in clock ticks since boot of process pid
+*/
+func procStart(pid int) (string, uint64, error) {
+	stat, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return "", 0, err
+	}
+	//the command name may contain spaces & parentheses, fields are counted after its closing parenthesis
+	i := strings.LastIndexByte(string(stat), ')')
+	fields := strings.Fields(string(stat[i+1:]))
+	//starttime is the 22nd field, the 20th after the command name
+	if i < 0 || len(fields) < 20 {
+		return "", 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	start, err := strconv.ParseUint(fields[19], 10, 64)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid stat of process %d", pid)
+	}
+	return fields[0], start, nil
+}
+
+/*
+runAlive reports whether the run recorded in file is executing: process pid
+exists, is not a zombie and started at the time recorded in file, so that a
+pid reused by another process does not keep a dead run alive
+*/
+func runAlive(file string, pid int) bool {
+	if syscall.Kill(pid, 0) == syscall.ESRCH {
+		return false
+	}
+	state, start, err := procStart(pid)
+	if os.IsNotExist(err) {
+		_, err := os.Stat(filepath.Join(procRoot, "self", "stat"))
+		return os.IsNotExist(err)
+	}
+	if err != nil || state == "Z" {
+		return false
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return false
+	}
+	recorded, err := strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64)
+	return err == nil && recorded == start
+}
+
+/*
+others returns the pids of runs other than the current process
+*/
+func others(pids []int) []int {
+	filtered := make([]int, 0, len(pids))
+	for _, pid := range pids {
+		if pid != os.Getpid() {
+			filtered = append(filtered, pid)
+		}
+	}
+	return filtered
+}
+
+func runFile(name string, pid int) string {
+	return filepath.Join(RUNS_PATH, fmt.Sprintf("%v.%d", name, pid))
+}
+
+func (r *runner) runFile() string {
+	return runFile(r.task.Name, os.Getpid())
+}
+
+/*
+RemoveRun removes the run file of the run of the task named name executed by
+process pid once the process was killed. It returns when the run was admitted,
+false if the process held no run file e.g while it was queued
+*/
+func RemoveRun(name string, pid int) (time.Time, bool, error) {
+	unlock, err := lockRuns()
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer unlock()
+	info, err := os.Stat(runFile(name, pid))
+	if os.IsNotExist(err) {
+		return time.Time{}, false, nil
+	}
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	return info.ModTime(), true, os.Remove(runFile(name, pid))
+}
+
+/*
+admit waits until the run may start according to the task's overlap policy and
+MAX_CONCURRENCY then marks the task as running. A waiting task is flagged IsQueued.
+It returns false if the run is skipped, force ignores the overlap policy but not
+MAX_CONCURRENCY
+*/
+func (r *runner) admit(ctx context.Context) (bool, error) {
+	max, err := MaxConcurrency()
+	if err != nil {
+		return false, err
+	}
+	queued := false
+	replaced := false
+	dequeue := func() {
+		if queued {
+			r.setIsQueued(false)
+		}
+	}
+	defer dequeue()
+	for {
+		unlock, err := lockRuns()
+		if err != nil {
+			return false, err
+		}
+		instances, err := RunInstances(r.task.Name)
+		if err != nil {
+			unlock()
+			return false, err
+		}
+		wait := ""
+		if len(others(instances)) > 0 && !r.force {
+			switch r.settings.Overlap {
+			case OverlapQueue:
+				wait = "its previous run is still executing"
+			case OverlapReplace:
+				wait = "its previous run is being stopped"
+				if !replaced {
+					r.replace(others(instances))
+					replaced = true
+				}
+			case OverlapAllow:
+			default:
+				unlock()
+				return false, nil
+			}
+		}
+		if wait == "" && max > 0 {
+			running, err := RunInstances("*")
+			if err != nil {
+				unlock()
+				return false, err
+			}
+			if n := len(others(running)); n >= max {
+				wait = fmt.Sprintf("%d of MAX_CONCURRENCY=%d tasks are running", n, max)
+			}
+		}
+		if wait == "" {
+			defer unlock()
+			if _, err := r.repo.SetIsRunning(r.task.Name, true); err != nil {
+				return false, err
+			}
+			content := ""
+			if _, start, err := procStart(os.Getpid()); err == nil {
+				content = fmt.Sprintf("%d\n", start)
+			}
+			return true, os.WriteFile(r.runFile(), []byte(content), 0644)
+		}
+		if !queued {
+			if err := r.setIsQueued(true); err != nil {
+				unlock()
+				return false, err
+			}
+			queued = true
+			log.Printf("task %v queued, %v", r.task.Name, wait)
+		}
+		unlock()
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		case <-time.After(queuePollInterval):
+		}
+	}
+}
+
+/*
+replace interrupts the previous runs of the task
+*/
+func (r *runner) replace(pids []int) {
+	for _, pid := range pids {
+		log.Printf("replacing run %d of task %v", pid, r.task.Name)
+		if err := syscall.Kill(pid, syscall.SIGINT); err != nil {
+			log.Printf("failed to interrupt run %d of task %v: %v", pid, r.task.Name, err)
+		}
+	}
+}
+
+/*
+release removes the run file of the current process, the task stays
+IsRunning while other runs of it are executing
+*/
+func (r *runner) release() {
+	unlock, err := lockRuns()
+	if err != nil {
+		log.Println(err)
+		return
+	}
+	defer unlock()
+	os.Remove(r.runFile())
+	instances, err := RunInstances(r.task.Name)
+	if err != nil || len(instances) > 0 {
+		return
+	}
+	if _, err := r.repo.SetIsRunning(r.task.Name, false); err != nil {
+		log.Println(err)
+	}
+}
+
+/*
+setIsQueued updates the IsQueued flag only, repo.SetIsQueued also clears
+IsRunning which is still true while a queued run waits for the previous one
+*/
+func (r *runner) setIsQueued(value bool) error {
+	return r.repo.DB.Model(&db.TaskModel{}).Where("name = ?", r.task.Name).Update("is_queued", value).Error
+}
+
+/*
+skipCode returns the exit code of a run refused by the overlap policy. Scheduled
+runs exit with success so that the scheduler does not flag the task IsError
+*/
+func (r *runner) skipCode() int {
+	if r.trigger == TriggerSchedule {
+		return ExitSuccess
+	}
+	return ExitSkipped
+}
@@ -0,0 +1,210 @@
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+/*
+procStatLine returns a /proc/<pid>/stat line of a process named comm
+*/
+func procStatLine(pid int, comm string, state string, start uint64) string {
+	return fmt.Sprintf("%d (%s) %s 1 1 1 0 -1 4194560 100 0 0 0 5 3 0 0 20 0 1 0 %d 1000 100", pid, comm, state, start)
+}
+
+func TestRunInstances(t *testing.T) {
+	exited := exec.Command("true")
+	if err := exited.Run(); err != nil {
+		t.Skipf("cannot start a process: %v", err)
+	}
+	pid := os.Getpid()
+	tests := []struct {
+		name     string
+		files    map[string]string
+		stat     string
+		noProc   bool
+		query    string
+		want     []int
+		wantKept []string
+	}{
+		{
+			name:     "start time matches",
+			files:    map[string]string{fmt.Sprintf("report.%d", pid): "4200\n"},
+			stat:     procStatLine(pid, "report_run.bin", "S", 4200),
+			query:    "report",
+			want:     []int{pid},
+			wantKept: []string{fmt.Sprintf("report.%d", pid)},
+		},
+		{
+			name:  "pid reused by another process",
+			files: map[string]string{fmt.Sprintf("report.%d", pid): "4200\n"},
+			stat:  procStatLine(pid, "report_run.bin", "S", 9100),
+			query: "report",
+			want:  []int{},
+		},
+		{
+			name:  "zombie",
+			files: map[string]string{fmt.Sprintf("report.%d", pid): "4200\n"},
+			stat:  procStatLine(pid, "report_run.bin", "Z", 4200),
+			query: "report",
+			want:  []int{},
+		},
+		{
+			name:  "command name with a parenthesis",
+			files: map[string]string{fmt.Sprintf("report.%d", pid): "4200\n"},
+			stat:  procStatLine(pid, "a) S 1 (b", "S", 4200),
+			query: "report",
+			want:  []int{pid},
+			wantKept: []string{
+				fmt.Sprintf("report.%d", pid),
+			},
+		},
+		{
+			name:  "exited",
+			files: map[string]string{fmt.Sprintf("report.%d", exited.Process.Pid): "4200\n"},
+			query: "report",
+			want:  []int{},
+		},
+		{
+			name:     "no start time without /proc",
+			files:    map[string]string{fmt.Sprintf("report.%d", pid): ""},
+			noProc:   true,
+			query:    "report",
+			want:     []int{pid},
+			wantKept: []string{fmt.Sprintf("report.%d", pid)},
+		},
+		{
+			name: "runs of a task with a longer name",
+			files: map[string]string{
+				fmt.Sprintf("report.daily.%d", pid): "4200\n",
+			},
+			stat:     procStatLine(pid, "report_run.bin", "S", 4200),
+			query:    "report",
+			want:     []int{},
+			wantKept: []string{fmt.Sprintf("report.daily.%d", pid)},
+		},
+		{
+			name: "all tasks",
+			files: map[string]string{
+				fmt.Sprintf("report.%d", pid): "4200\n",
+				"report.invalid":              "",
+			},
+			stat:     procStatLine(pid, "report_run.bin", "S", 4200),
+			query:    "*",
+			want:     []int{pid},
+			wantKept: []string{fmt.Sprintf("report.%d", pid), "report.invalid"},
+		},
+	}
+	defer func(runs, proc string) { RUNS_PATH, procRoot = runs, proc }(RUNS_PATH, procRoot)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RUNS_PATH = t.TempDir()
+			procRoot = t.TempDir()
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(RUNS_PATH, name), []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if !tt.noProc {
+				for _, dir := range []string{"self", strconv.Itoa(pid)} {
+					if err := os.MkdirAll(filepath.Join(procRoot, dir), 0755); err != nil {
+						t.Fatal(err)
+					}
+				}
+				if err := os.WriteFile(filepath.Join(procRoot, "self", "stat"), []byte(tt.stat), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if tt.stat != "" {
+					if err := os.WriteFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"), []byte(tt.stat), 0644); err != nil {
+						t.Fatal(err)
+					}
+				}
+			}
+			got, err := RunInstances(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("RunInstances(%q) = %v, want %v", tt.query, got, tt.want)
+			}
+			kept := []string{}
+			entries, err := os.ReadDir(RUNS_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, entry := range entries {
+				kept = append(kept, entry.Name())
+			}
+			if !reflect.DeepEqual(kept, append([]string{}, tt.wantKept...)) {
+				t.Errorf("RunInstances(%q) kept %v, want %v", tt.query, kept, tt.wantKept)
+			}
+		})
+	}
+}
+
+func TestRemoveRun(t *testing.T) {
+	defer func(runs string) { RUNS_PATH = runs }(RUNS_PATH)
+	tests := []struct {
+		name         string
+		files        []string
+		task         string
+		pid          int
+		wantAdmitted bool
+		wantKept     []string
+	}{
+		{
+			name:         "admitted run",
+			files:        []string{"report.4242", "report.4343"},
+			task:         "report",
+			pid:          4242,
+			wantAdmitted: true,
+			wantKept:     []string{"report.4343"},
+		},
+		{
+			name:     "queued run",
+			files:    []string{"report.4343"},
+			task:     "report",
+			pid:      4242,
+			wantKept: []string{"report.4343"},
+		},
+		{
+			name:     "run of another task",
+			files:    []string{"backup.4242"},
+			task:     "report",
+			pid:      4242,
+			wantKept: []string{"backup.4242"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			RUNS_PATH = t.TempDir()
+			for _, name := range tt.files {
+				if err := os.WriteFile(filepath.Join(RUNS_PATH, name), []byte("4200\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			start, admitted, err := RemoveRun(tt.task, tt.pid)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if admitted != tt.wantAdmitted || admitted == start.IsZero() {
+				t.Errorf("RemoveRun(%v, %d) = %v %v, want admitted %v", tt.task, tt.pid, start, admitted, tt.wantAdmitted)
+			}
+			kept, err := filepath.Glob(filepath.Join(RUNS_PATH, "[^.]*"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range kept {
+				kept[i] = filepath.Base(kept[i])
+			}
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("RemoveRun(%v, %d) kept %v, want %v", tt.task, tt.pid, kept, tt.wantKept)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package runner
+
+import (
+	"math/rand"
+	"regexp"
+	"time"
+)
+
+/*
+Retryable returns true if a run that failed with err should be retried
+*/
+func (p *Retry) Retryable(err error) bool {
+	if p.Fatal != "" && regexp.MustCompile(p.Fatal).MatchString(err.Error()) {
+		return false
+	}
+	if p.RetryOn != "" {
+		return regexp.MustCompile(p.RetryOn).MatchString(err.Error())
+	}
+	return true
+}
+
+/*
+delay returns the wait before the retry following the given attempt: Delay,
+doubled after every attempt with exponential backoff, plus a random wait of up
+to Jitter of it. random returns a number in [0, 1)
+*/
+func (p *Retry) delay(attempt int, random func() float64) time.Duration {
+	delay := p.Delay
+	for i := 1; i < attempt && p.Backoff == ExponentialBackoff; i++ {
+		delay *= 2
+	}
+	return delay + time.Duration(float64(delay)*p.Jitter*random())
+}
+
+/*
+attempts executes Function until it succeeds, fails with an error that is not
+retryable or the attempts of the retry policy are exhausted. Every failed attempt
+that is retried is recorded, the last attempt is recorded by finish
+*/
+func (r *runner) attempts() error {
+	retry := &r.settings.Retry
+	for {
+		r.begin()
+		err := r.call()
+		if err == nil || r.attempt >= retry.Attempts || !retry.Retryable(err) {
+			return err
+		}
+		r.retrying(err)
+		r.sleep(retry.delay(r.attempt, rand.Float64))
+	}
+}
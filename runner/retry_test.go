@@ -0,0 +1,176 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+func TestRetryDelay(t *testing.T) {
+	tests := []struct {
+		name    string
+		retry   Retry
+		attempt int
+		random  float64
+		want    time.Duration
+	}{
+		{
+			name:    "fixed",
+			retry:   Retry{Backoff: FixedBackoff, Delay: 10 * time.Second},
+			attempt: 3,
+			want:    10 * time.Second,
+		},
+		{
+			name:    "exponential after the first attempt",
+			retry:   Retry{Backoff: ExponentialBackoff, Delay: 10 * time.Second},
+			attempt: 1,
+			want:    10 * time.Second,
+		},
+		{
+			name:    "exponential after the third attempt",
+			retry:   Retry{Backoff: ExponentialBackoff, Delay: 10 * time.Second},
+			attempt: 3,
+			want:    40 * time.Second,
+		},
+		{
+			name:    "jitter is a fraction of the delay",
+			retry:   Retry{Backoff: FixedBackoff, Delay: 10 * time.Second, Jitter: 0.2},
+			attempt: 1,
+			random:  0.5,
+			want:    11 * time.Second,
+		},
+		{
+			name:    "jitter of the exponential delay",
+			retry:   Retry{Backoff: ExponentialBackoff, Delay: 10 * time.Second, Jitter: 0.5},
+			attempt: 2,
+			random:  0.5,
+			want:    25 * time.Second,
+		},
+		{
+			name:    "no delay",
+			retry:   Retry{Backoff: ExponentialBackoff, Jitter: 1},
+			attempt: 2,
+			random:  0.9,
+			want:    0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.retry.delay(tt.attempt, func() float64 { return tt.random })
+			if got != tt.want {
+				t.Errorf("delay(%d) = %v, want %v", tt.attempt, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAttempts(t *testing.T) {
+	tests := []struct {
+		name         string
+		retry        Retry
+		errs         []error
+		wantErr      bool
+		wantAttempts int
+		wantSleeps   []time.Duration
+	}{
+		{
+			name:         "first attempt succeeds",
+			retry:        Retry{Attempts: 3, Backoff: FixedBackoff, Delay: time.Second},
+			errs:         []error{nil},
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds on a retry",
+			retry:        Retry{Attempts: 3, Backoff: ExponentialBackoff, Delay: time.Second},
+			errs:         []error{fmt.Errorf("timeout"), fmt.Errorf("timeout"), nil},
+			wantAttempts: 3,
+			wantSleeps:   []time.Duration{time.Second, 2 * time.Second},
+		},
+		{
+			name:         "attempts exhausted",
+			retry:        Retry{Attempts: 2, Backoff: FixedBackoff, Delay: time.Second},
+			errs:         []error{fmt.Errorf("timeout"), fmt.Errorf("timeout"), nil},
+			wantErr:      true,
+			wantAttempts: 2,
+			wantSleeps:   []time.Duration{time.Second},
+		},
+		{
+			name:         "fatal error stops the run",
+			retry:        Retry{Attempts: 3, Backoff: FixedBackoff, Delay: time.Second, Fatal: "401"},
+			errs:         []error{fmt.Errorf("status 401"), nil},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "error not matching retry-on",
+			retry:        Retry{Attempts: 3, Backoff: FixedBackoff, Delay: time.Second, RetryOn: "timeout"},
+			errs:         []error{fmt.Errorf("invalid input"), nil},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+		{
+			name:         "no retry policy",
+			retry:        Retry{Attempts: 1, Backoff: FixedBackoff, Delay: time.Second},
+			errs:         []error{fmt.Errorf("timeout"), nil},
+			wantErr:      true,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := testDB(t)
+			calls := 0
+			sleeps := []time.Duration{}
+			settings := NewTaskSettings("report")
+			settings.Retry = tt.retry
+			r := &runner{
+				task:     &db.TaskModel{Name: "report", LogPath: filepath.Join(t.TempDir(), "report.log")},
+				settings: settings,
+				repo:     &db.Repo{DB: conn},
+				function: func() error {
+					calls++
+					return tt.errs[calls-1]
+				},
+				mask:  strings.NewReplacer(),
+				sleep: func(d time.Duration) { sleeps = append(sleeps, d) },
+			}
+			if err := r.attempts(); (err != nil) != tt.wantErr {
+				t.Errorf("attempts() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if calls != tt.wantAttempts || r.attempt != tt.wantAttempts {
+				t.Errorf("attempts() executed Function %d times in %d attempts, want %d", calls, r.attempt, tt.wantAttempts)
+			}
+			if !reflect.DeepEqual(sleeps, append([]time.Duration{}, tt.wantSleeps...)) {
+				t.Errorf("attempts() slept %v, want %v", sleeps, tt.wantSleeps)
+			}
+			var recorded int64
+			if err := conn.Model(&TaskRun{}).Count(&recorded).Error; err != nil {
+				t.Fatal(err)
+			}
+			if int(recorded) != len(tt.wantSleeps) {
+				t.Errorf("attempts() recorded %d retried attempts, want %d", recorded, len(tt.wantSleeps))
+			}
+		})
+	}
+}
+
+/*
+testDB returns a sqlite database holding the history of runs
+*/
+func testDB(t *testing.T) *gorm.DB {
+	conn, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "keiji.db")), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.AutoMigrate(&TaskRun{}); err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
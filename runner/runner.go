@@ -0,0 +1,312 @@
+/*
+Package runner executes the Function of a task built with `keiji task build`.
+
+The cli copies this package into the workspace and adds a keiji_run.go file calling
+Intercept to every task it builds, so that every run of a task executable goes
+through the runner whether it is started by the scheduler or by `keiji task run`
+*/
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+)
+
+// TriggerEnv names the source of a run, runs started without it are scheduled runs
+const TriggerEnv = "KEIJI_TRIGGER"
+
+// exit codes of a task executable
+const (
+	ExitSuccess     = 0
+	ExitError       = 1
+	ExitTimeout     = 124
+	ExitInterrupted = 130
+)
+
+/*
+Intercept takes over the task executable when it is started with --run and exits
+with the outcome of the run. Other invocations e.g --schedule are left to main
+*/
+func Intercept(function func() error) {
+	if !runRequested(os.Args[1:]) {
+		return
+	}
+	os.Exit(Run(function))
+}
+
+/*
+runRequested reports whether args hold the --run flag of the task template
+*/
+func runRequested(args []string) bool {
+	for _, arg := range args {
+		switch arg {
+		case "-run", "--run", "-run=true", "--run=true":
+			return true
+		}
+	}
+	return false
+}
+
+/*
+Run executes function once as a run of the task the executable belongs to and
+records the run in the task's history. It returns the exit code of the run
+*/
+func Run(function func() error) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	repo, err := db.NewRepo()
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	task, err := executableTask(repo)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	if task == nil {
+		log.Println("executable does not belong to a task built with `keiji task build`, the run is not recorded")
+		if err := function(); err != nil {
+			log.Println(err)
+			return ExitError
+		}
+		return ExitSuccess
+	}
+	settings, err := loadSettings(repo, task.Name)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	timeout, err := runTimeout(settings)
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	r := &runner{
+		task:           task,
+		settings:       settings,
+		timeout:        timeout,
+		repo:           repo,
+		function:       function,
+		trigger:        os.Getenv(TriggerEnv),
+		skipDownstream: os.Getenv(SkipDownstreamEnv) != "",
+		force:          os.Getenv(ForceEnv) != "",
+		environ:        CleanEnv(os.Environ()),
+		secretsKey:     os.Getenv(SecretsKeyEnv),
+		mask:           strings.NewReplacer(),
+		exit:           os.Exit,
+		sleep:          time.Sleep,
+	}
+	if r.trigger == "" {
+		r.trigger = TriggerSchedule
+	}
+	if timeout > 0 {
+		r.group = ownProcessGroup()
+	}
+	if err := r.loadEnv(); err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	return r.run(ctx)
+}
+
+/*
+executableTask returns the task whose executable is running, nil if there is none.
+The scheduler runs a copy of the executable suffixed with _run, and a renamed task
+keeps working as tasks are matched by their executable rather than a name baked in
+at build time
+*/
+func executableTask(repo *db.Repo) (*db.TaskModel, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	base := filepath.Base(exe)
+	names := []string{base}
+	if trimmed := strings.TrimSuffix(base, "_run.bin"); trimmed != base {
+		names = append(names, trimmed+".bin")
+	}
+	tasks := make([]*db.TaskModel, 0)
+	if err := repo.DB.Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		for _, task := range tasks {
+			if filepath.Base(task.Executable) == name {
+				return task, nil
+			}
+		}
+	}
+	return nil, nil
+}
+
+/*
+runner executes the Function of a task and records its runs
+*/
+type runner struct {
+	task     *db.TaskModel
+	settings *TaskSettings
+	//timeout limits every attempt of the run, 0 disables it
+	timeout time.Duration
+	//group is the process group the process was started in, the run has its own group when it has a timeout
+	group    int
+	repo     *db.Repo
+	function func() error
+	trigger  string
+	//skipDownstream prevents a successful run from starting the tasks depending on it
+	skipDownstream bool
+	//force ignores the overlap policy of the task
+	force bool
+	//environ is the environment the process was started with, without InternalEnv
+	environ []string
+	//secretsKey decrypts the secrets of downstream runs when the secrets key is provided through SecretsKeyEnv
+	secretsKey string
+	//mask hides the values of the task's secrets
+	mask *strings.Replacer
+	//exit terminates the process when a run is aborted while Function is executing
+	exit func(code int)
+	//sleep waits between the attempts of the run
+	sleep func(d time.Duration)
+	//fireTime is the fire time of the task's cron expression claimed by the run
+	fireTime *time.Time
+
+	mu        sync.Mutex
+	attempt   int
+	start     time.Time
+	logOffset int64
+	//admitted is true once the run holds a run file
+	admitted bool
+	//recorded is true while the runner waits to retry an attempt it recorded
+	recorded bool
+	finished bool
+	code     int
+}
+
+/*
+run executes Function once the task's cron expression, upstream tasks, overlap
+policy and MAX_CONCURRENCY admit it, until it succeeds or the task's retry policy
+is exhausted. It is aborted when ctx is done, a successful run starts the tasks
+depending on it
+*/
+func (r *runner) run(ctx context.Context) int {
+	//cron tasks are polled on their HMS schedule, polls without a new fire time exit silently
+	due, err := r.due(time.Now())
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	if !due {
+		return ExitSuccess
+	}
+	ready, err := r.ready()
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	if !ready {
+		log.Printf("skipping scheduled run of %v, waiting for its upstream tasks %v to succeed", r.task.Name, r.settings.DependsOn)
+		return ExitSuccess
+	}
+	admitted, err := r.admit(ctx)
+	if err != nil && ctx.Err() != nil {
+		return ExitInterrupted
+	}
+	if err != nil {
+		log.Println(err)
+		return ExitError
+	}
+	if !admitted {
+		log.Printf("task %v is already running, run skipped by its overlap policy", r.task.Name)
+		return r.skipCode()
+	}
+	r.admitted = true
+	done := make(chan struct{})
+	defer close(done)
+	go r.watch(ctx, done)
+	if err := r.attempts(); err != nil {
+		return r.finish(StatusError, err)
+	}
+	code := r.finish(StatusSuccess, nil)
+	if code == ExitSuccess {
+		r.runDownstream()
+	}
+	return code
+}
+
+/*
+watch aborts the run when the process is interrupted. Function cannot be
+cancelled, so the process exits once the run is recorded
+*/
+func (r *runner) watch(ctx context.Context, done <-chan struct{}) {
+	select {
+	case <-done:
+		return
+	case <-ctx.Done():
+	}
+	r.exit(r.finish(StatusInterrupted, fmt.Errorf("interrupted")))
+}
+
+/*
+begin starts a new attempt of the run
+*/
+func (r *runner) begin() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.attempt++
+	r.start = time.Now()
+	r.recorded = false
+	r.logOffset = 0
+	if info, err := os.Stat(r.task.LogPath); err == nil {
+		r.logOffset = info.Size()
+	}
+}
+
+/*
+finish records the outcome of the run once and returns the exit code of the
+outcome recorded first. The error of a failed run is logged as the last line
+of the task's output
+*/
+func (r *runner) finish(status string, err error) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.finished {
+		return r.code
+	}
+	r.finished = true
+	r.code = exitCodes[status]
+	if !r.recorded {
+		if err := r.save(status, err); err != nil {
+			log.Printf("failed to record run of task %v: %v", r.task.Name, err)
+		}
+	}
+	if r.admitted {
+		r.release()
+	}
+	if err != nil {
+		log.Println(r.mask.Replace(err.Error()))
+	}
+	return r.code
+}
+
+/*
+retrying records the failed attempt of a run that is retried
+*/
+func (r *runner) retrying(err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.save(StatusError, err); err != nil {
+		log.Printf("failed to record run of task %v: %v", r.task.Name, err)
+	}
+	r.recorded = true
+	log.Printf("attempt %d of %d failed: %v", r.attempt, r.settings.Retry.Attempts, r.mask.Replace(err.Error()))
+}
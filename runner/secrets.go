@@ -0,0 +1,140 @@
+package runner
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/aodr3w/keiji-core/paths"
+)
+
+// SecretsKeyEnv provides the base64 encoded key of the secrets store instead of SECRETS_KEY_PATH
+const SecretsKeyEnv = "KEIJI_SECRETS_KEY"
+
+var (
+	// SECRETS_PATH holds the encrypted secrets of every task
+	SECRETS_PATH = filepath.Join(paths.SYSTEM_ROOT, "secrets.json")
+	// SECRETS_KEY_PATH holds the AES-256 key encrypting the secrets, it is created by `keiji task env set --secret`
+	SECRETS_KEY_PATH = filepath.Join(paths.SYSTEM_ROOT, "secrets.key")
+)
+
+/*
+CreateSecretsKey creates SECRETS_KEY_PATH with a random key unless it exists.
+The file is created exclusively, so concurrent callers never replace a key
+that already encrypts secrets
+*/
+func CreateSecretsKey() error {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(SECRETS_KEY_PATH, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0600)
+	if os.IsExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(key); err != nil {
+		f.Close()
+		os.Remove(SECRETS_KEY_PATH)
+		return err
+	}
+	return f.Close()
+}
+
+/*
+SecretsCipher returns the AES-GCM cipher of the secrets store. The key is read from
+KEIJI_SECRETS_KEY (base64) when set, otherwise from SECRETS_KEY_PATH
+*/
+func SecretsCipher() (cipher.AEAD, error) {
+	return secretsCipher(os.Getenv(SecretsKeyEnv))
+}
+
+/*
+secretsCipher returns the AES-GCM cipher of the secrets store, the key is
+decoded from encodedKey unless it is empty
+*/
+func secretsCipher(encodedKey string) (cipher.AEAD, error) {
+	var key []byte
+	var err error
+	if encodedKey != "" {
+		key, err = base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %v, expected base64: %v", SecretsKeyEnv, err)
+		}
+	} else {
+		key, err = os.ReadFile(SECRETS_KEY_PATH)
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("secrets key %v not found, it is created by `keiji task env set --secret`", SECRETS_KEY_PATH)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("invalid secrets key, expected 32 bytes got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/*
+ReadSecretStore returns the encrypted secrets of every task keyed by task name then key
+*/
+func ReadSecretStore() (map[string]map[string]string, error) {
+	store := make(map[string]map[string]string)
+	content, err := os.ReadFile(SECRETS_PATH)
+	if os.IsNotExist(err) {
+		return store, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &store); err != nil {
+		return nil, fmt.Errorf("invalid secrets store %v: %v", SECRETS_PATH, err)
+	}
+	return store, nil
+}
+
+/*
+TaskSecrets returns the decrypted secrets of the task named name
+*/
+func TaskSecrets(name string) (map[string]string, error) {
+	return taskSecrets(name, os.Getenv(SecretsKeyEnv))
+}
+
+func taskSecrets(name string, encodedKey string) (map[string]string, error) {
+	store, err := ReadSecretStore()
+	if err != nil {
+		return nil, err
+	}
+	secrets := make(map[string]string, len(store[name]))
+	if len(store[name]) == 0 {
+		return secrets, nil
+	}
+	aead, err := secretsCipher(encodedKey)
+	if err != nil {
+		return nil, err
+	}
+	for key, encoded := range store[name] {
+		sealed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(sealed) < aead.NonceSize() {
+			return nil, fmt.Errorf("secret %v of task %v is corrupted", key, name)
+		}
+		value, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+		if err != nil {
+			return nil, fmt.Errorf("cannot decrypt secret %v of task %v, was the secrets key replaced?", key, name)
+		}
+		secrets[key] = string(value)
+	}
+	return secrets, nil
+}
@@ -0,0 +1,62 @@
+package runner
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateSecretsKey(t *testing.T) {
+	defer func(path string) { SECRETS_KEY_PATH = path }(SECRETS_KEY_PATH)
+	existing := bytes.Repeat([]byte{7}, 32)
+	tests := []struct {
+		name     string
+		existing []byte
+	}{
+		{name: "missing key"},
+		{name: "existing key is kept", existing: existing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			SECRETS_KEY_PATH = filepath.Join(t.TempDir(), "secrets.key")
+			if tt.existing != nil {
+				if err := os.WriteFile(SECRETS_KEY_PATH, tt.existing, 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := CreateSecretsKey(); err != nil {
+				t.Fatalf("CreateSecretsKey() error = %v", err)
+			}
+			key, err := os.ReadFile(SECRETS_KEY_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(key) != 32 {
+				t.Errorf("key length = %d, want 32", len(key))
+			}
+			if tt.existing != nil && !bytes.Equal(key, tt.existing) {
+				t.Errorf("CreateSecretsKey() replaced the existing key")
+			}
+			info, err := os.Stat(SECRETS_KEY_PATH)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.existing == nil && info.Mode().Perm() != 0600 {
+				t.Errorf("key mode = %v, want 0600", info.Mode().Perm())
+			}
+		})
+	}
+}
+
+func TestSecretsCipherMissingKey(t *testing.T) {
+	t.Setenv(SecretsKeyEnv, "")
+	defer func(path string) { SECRETS_KEY_PATH = path }(SECRETS_KEY_PATH)
+	SECRETS_KEY_PATH = filepath.Join(t.TempDir(), "secrets.key")
+	if _, err := SecretsCipher(); err == nil {
+		t.Fatal("SecretsCipher() without a key succeeded")
+	}
+	if _, err := os.Stat(SECRETS_KEY_PATH); !os.IsNotExist(err) {
+		t.Errorf("SecretsCipher() created %v", SECRETS_KEY_PATH)
+	}
+}
@@ -0,0 +1,124 @@
+package runner
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+	"gorm.io/gorm"
+)
+
+// overlap policies, applied when a task is started while a previous run is still executing
+const (
+	OverlapSkip    = "skip"
+	OverlapQueue   = "queue"
+	OverlapAllow   = "allow"
+	OverlapReplace = "replace"
+)
+
+// backoff strategies of a retry policy
+const (
+	FixedBackoff       = "fixed"
+	ExponentialBackoff = "exponential"
+)
+
+// DefaultRetryDelay is the delay before a retry when none is declared
+const DefaultRetryDelay = 10 * time.Second
+
+/*
+Retry describes how a failing run of a task is retried
+*/
+type Retry struct {
+	//Attempts is the maximum number of executions, including the first one
+	Attempts int
+	Backoff  string
+	//Delay is waited before the first retry, in whole seconds
+	Delay time.Duration
+	//Jitter adds a random wait of up to this fraction of the delay before each retry
+	Jitter float64
+	//RetryOn limits retries to errors matching this regular expression when set
+	RetryOn string
+	//Fatal errors matching this regular expression are never retried
+	Fatal string
+}
+
+/*
+Validate returns an error if the policy cannot be applied
+*/
+func (p *Retry) Validate() error {
+	if p.Attempts < 1 {
+		return fmt.Errorf("retry attempts must be at least 1")
+	}
+	if p.Backoff != FixedBackoff && p.Backoff != ExponentialBackoff {
+		return fmt.Errorf("invalid retry backoff %q, must be fixed or exponential", p.Backoff)
+	}
+	if p.Delay < 0 || p.Delay%time.Second != 0 {
+		return fmt.Errorf("invalid retry delay %v, must be a whole number of seconds e.g 10s", p.Delay)
+	}
+	if p.Jitter < 0 || p.Jitter > 1 {
+		return fmt.Errorf("retry jitter must be between 0 and 1")
+	}
+	for _, pattern := range []string{p.RetryOn, p.Fatal} {
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid retry pattern %q: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+func (p *Retry) String() string {
+	if p.Attempts <= 1 {
+		return "none"
+	}
+	return fmt.Sprintf("%d attempts, %v backoff from %v", p.Attempts, p.Backoff, p.Delay)
+}
+
+/*
+TaskSettings holds the settings a task declares through directives in its schedule.go.
+They are saved by `keiji task build` and read by the runner on every run
+*/
+type TaskSettings struct {
+	gorm.Model
+	TaskName string `gorm:"uniqueIndex"`
+	Retry    Retry  `gorm:"embedded;embeddedPrefix:retry_"`
+	//Timeout kills runs of the task that take longer, 0 disables it
+	Timeout time.Duration
+	//Overlap decides what happens when the task is started while it is running
+	Overlap string `gorm:"default:skip"`
+	//DependsOn lists the upstream tasks that must succeed before the task runs
+	DependsOn []string `gorm:"serializer:json"`
+	//Cron limits scheduled runs to the fire times of this expression when set
+	Cron string
+}
+
+func (TaskSettings) TableName() string {
+	return "task_settings"
+}
+
+/*
+NewTaskSettings returns the settings of a task that declares no directives
+*/
+func NewTaskSettings(name string) *TaskSettings {
+	return &TaskSettings{
+		TaskName: name,
+		Retry:    Retry{Attempts: 1, Backoff: FixedBackoff, Delay: DefaultRetryDelay},
+		Overlap:  OverlapSkip,
+	}
+}
+
+/*
+loadSettings returns the stored settings of the task named name,
+or the defaults if the task was built without any
+*/
+func loadSettings(repo *db.Repo, name string) (*TaskSettings, error) {
+	settings := []*TaskSettings{}
+	err := repo.DB.Where("task_name = ?", name).Limit(1).Find(&settings).Error
+	if err != nil {
+		return nil, err
+	}
+	if len(settings) == 0 {
+		return NewTaskSettings(name), nil
+	}
+	return settings[0], nil
+}
@@ -0,0 +1,10 @@
+package runner
+
+import "embed"
+
+/*
+Source holds the files of this package. `keiji task build` copies them into the
+workspace so that task executables can import the runner
+*/
+//go:embed *.go
+var Source embed.FS
@@ -0,0 +1,89 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"syscall"
+	"time"
+)
+
+// TimeoutEnv overrides the timeout of a single run, set by `keiji task run --timeout`
+const TimeoutEnv = "KEIJI_TIMEOUT"
+
+/*
+runTimeout returns the timeout of the run, 0 if runs of the task are not limited
+*/
+func runTimeout(settings *TaskSettings) (time.Duration, error) {
+	value := os.Getenv(TimeoutEnv)
+	if value == "" {
+		return settings.Timeout, nil
+	}
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid %v %q, expected a duration such as 30s or 5m", TimeoutEnv, value)
+	}
+	return timeout, nil
+}
+
+/*
+ownProcessGroup makes the process the leader of a new process group, which the
+processes started by Function inherit, and returns the group it was started in
+*/
+func ownProcessGroup() int {
+	group := syscall.Getpgrp()
+	if group != os.Getpid() {
+		if err := syscall.Setpgid(0, 0); err != nil {
+			log.Printf("failed to create the process group of the run: %v", err)
+		}
+	}
+	return group
+}
+
+/*
+killProcessGroup kills the processes Function left in the process group of the
+run. The process first returns to the group it was started in so that it exits
+with the code of the run, a run started as the leader of its group e.g a
+downstream run is killed along with it
+*/
+func (r *runner) killProcessGroup() {
+	pid := os.Getpid()
+	if syscall.Getpgrp() != pid {
+		return
+	}
+	if r.group != pid {
+		if err := syscall.Setpgid(0, r.group); err != nil {
+			log.Printf("failed to leave the process group of the run: %v", err)
+		}
+	}
+	syscall.Kill(-pid, syscall.SIGKILL)
+}
+
+/*
+call executes Function once under a context deadline of the run's timeout.
+Function cannot be cancelled, so a run exceeding its deadline is recorded as
+timed out, the processes it started are killed and the process exits without
+retrying it
+*/
+func (r *runner) call() error {
+	if r.timeout <= 0 {
+		return r.function()
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), r.timeout)
+	defer cancel()
+	errs := make(chan error, 1)
+	go func() {
+		errs <- r.function()
+	}()
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+		err := fmt.Errorf("timed out after %v", r.timeout)
+		code := r.finish(StatusTimeout, err)
+		r.killProcessGroup()
+		r.exit(code)
+		return err
+	}
+}
@@ -0,0 +1,58 @@
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/aodr3w/keiji-core/db"
+)
+
+func TestCallTimeoutKillsProcessGroup(t *testing.T) {
+	if syscall.Getpgrp() == os.Getpid() {
+		t.Skip("the test process leads its process group, killing the group would kill the test")
+	}
+	exited := make(chan *os.ProcessState, 1)
+	code := -1
+	r := &runner{
+		task:     &db.TaskModel{Name: "report", LogPath: filepath.Join(t.TempDir(), "report.log")},
+		settings: NewTaskSettings("report"),
+		timeout:  200 * time.Millisecond,
+		repo:     &db.Repo{DB: testDB(t)},
+		function: func() error {
+			cmd := exec.Command("sleep", "30")
+			if err := cmd.Start(); err != nil {
+				return err
+			}
+			err := cmd.Wait()
+			exited <- cmd.ProcessState
+			return err
+		},
+		mask: strings.NewReplacer(),
+		exit: func(c int) { code = c },
+	}
+	r.group = ownProcessGroup()
+	defer syscall.Setpgid(0, r.group)
+	r.begin()
+	if err := r.call(); err == nil {
+		t.Fatal("call() exceeding its timeout succeeded")
+	}
+	if code != ExitTimeout {
+		t.Errorf("call() exited with %d, want %d", code, ExitTimeout)
+	}
+	if syscall.Getpgrp() != r.group {
+		t.Errorf("call() left the process in group %d, want %d", syscall.Getpgrp(), r.group)
+	}
+	select {
+	case state := <-exited:
+		if status, ok := state.Sys().(syscall.WaitStatus); !ok || status.Signal() != syscall.SIGKILL {
+			t.Errorf("child process exited with %v, want killed", state)
+		}
+	case <-time.After(5 * time.Second):
+		t.Error("child process survived the timeout")
+	}
+}